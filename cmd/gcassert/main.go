@@ -1,25 +1,231 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/fmstephe/gcassert"
+	"github.com/fmstephe/gcassert/watch"
 )
 
+var (
+	race         = flag.Bool("race", false, "build with the race detector enabled")
+	toolchains   = flag.String("toolchains", "", "comma-separated list of Go toolchains to check, such as go1.21.0,go1.22.0")
+	strict       = flag.Bool("strict", false, "fail on comments that look like malformed gcassert directives, on bce and noescape directives with nothing to check, and on inline functions that are never called")
+	escapetrace  = flag.Bool("escapetrace", false, "add the escape analysis of the enclosing function to each noescape failure")
+	gcflags      = flag.String("gcflags", "", "space-separated list of extra flags to build with, such as -B, added to the compiler flags that gcassert needs")
+	tests        = flag.Bool("tests", false, "also check the directives in _test.go files")
+	cachedir     = flag.String("cache", "", "directory to cache the compiler output of each package in, to skip building unchanged packages")
+	clearcache   = flag.Bool("clearcache", false, "clear the cache directory before the run")
+	goos         = flag.String("goos", "", "operating system to build for, instead of $GOOS")
+	goarch       = flag.String("goarch", "", "architecture to build for, instead of $GOARCH")
+	tags         = flag.String("tags", "", "comma-separated list of build tags to load and build with, such as purego")
+	deps         = flag.Bool("deps", false, "also check the directives in the dependencies of the packages, other than the standard library")
+	directives   = flag.String("directives", "", "file listing directives for code that can't carry gcassert comments, such as generated code")
+	failon       = flag.String("failon", "", "comma-separated list of directives whose failures fail the run, such as bce; other failures are only warnings")
+	lines        = flag.String("lines", "", "comma-separated list of file:start-end line ranges, such as those changed by a commit, to report failures on")
+	abspaths     = flag.Bool("abspaths", false, "print the absolute paths of failures, rather than paths relative to the working directory")
+	pathroot     = flag.String("pathroot", "", "directory to print the paths of failures relative to, instead of the working directory")
+	nosummary    = flag.Bool("nosummary", false, "don't print the summary line with the number of directives checked and failed")
+	nolog        = flag.Bool("nolog", false, "don't write the compiler's full output to a temporary log file")
+	deletelog    = flag.Bool("deletelog", false, "delete the log of the compiler's full output if no directive fails")
+	watching     = flag.Bool("watch", false, "keep running, and check the packages again each time one of their Go files changes")
+	coverprofile = flag.String("coverprofile", "", "write a coverage profile of the statements covered by directives to this file, instead of checking them")
+	list         = flag.Bool("list", false, "print the directives found on each line, and the callsites checked for inlining, instead of checking them")
+)
+
+// ignore is the patterns of the -ignore flag, which can be repeated.
+var ignore []*regexp.Regexp
+
+func init() {
+	flag.Func("ignore", "regexp of compiler messages to ignore, such as known escapes; can be repeated", func(s string) error {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return err
+		}
+		ignore = append(ignore, re)
+		return nil
+	})
+}
+
 func main() {
-	flag.Parse()
+	// Usage errors exit with gcassert.ExitConfigError, rather than the flag
+	// package's 2, which means the build failed.
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(os.Args[1:]); errors.Is(err, flag.ErrHelp) {
+		os.Exit(gcassert.ExitOK)
+	} else if err != nil {
+		os.Exit(gcassert.ExitConfigError)
+	}
 	var buf strings.Builder
-	err := gcassert.GCAssert(&buf, flag.Args()...)
+	opts := gcassert.Options{
+		Race:             *race,
+		StrictDirectives: *strict,
+		EscapeTrace:      *escapetrace,
+		Tests:            *tests,
+		CacheDir:         *cachedir,
+		ClearCache:       *clearcache,
+		GOOS:             *goos,
+		GOARCH:           *goarch,
+		IgnoreMessages:   ignore,
+		DirectivesFile:   *directives,
+		AbsPaths:         *abspaths,
+		PathRoot:         *pathroot,
+		NoSummary:        *nosummary,
+		NoLog:            *nolog,
+		DeleteLog:        *deletelog,
+		Deps:             *deps,
+	}
+	if *toolchains != "" {
+		opts.Toolchains = strings.Split(*toolchains, ",")
+	}
+	opts.GCFlags = strings.Fields(*gcflags)
+	if *tags != "" {
+		opts.Tags = strings.Split(*tags, ",")
+	}
+	if *failon != "" {
+		opts.FailOn = strings.Split(*failon, ",")
+	}
+	if *lines != "" {
+		ranges, err := parseLineRanges(*lines)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(gcassert.ExitConfigError)
+		}
+		opts.Lines = ranges
+	}
+	paths, err := expandPaths(flag.Args(), os.Stdin)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(gcassert.ExitConfigError)
+	}
+	if *coverprofile != "" {
+		if err := writeCoverProfile(*coverprofile, opts, paths); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(gcassert.ExitCode(err))
+		}
+		return
 	}
-	output := buf.String()
-	if len(output) != 0 {
-		fmt.Fprint(os.Stderr, output)
-		os.Exit(1)
+	if *list {
+		if err := gcassert.GCAssertListWithOptions(os.Stdout, opts, paths...); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(gcassert.ExitCode(err))
+		}
+		return
+	}
+	if *watching {
+		if err := watch.WithOptions(os.Stderr, opts, paths...); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(gcassert.ExitCode(err))
+		}
+		return
+	}
+	err = gcassert.GCAssertWithOptions(&buf, opts, paths...)
+	if errors.Is(err, gcassert.ErrAssertionsFailed) {
+		fmt.Fprint(os.Stderr, buf.String())
+		os.Exit(gcassert.ExitAssertionsFailed)
+	}
+	if err != nil {
+		fmt.Fprint(os.Stderr, buf.String())
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(gcassert.ExitCode(err))
+	}
+	// Only warnings are left, which don't fail the run.
+	fmt.Fprint(os.Stderr, buf.String())
+}
+
+func writeCoverProfile(path string, opts gcassert.Options, paths []string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := gcassert.GCAssertCoverage(f, opts, paths...); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// expandPaths replaces each argument that is "-" with the package patterns
+// read from stdin, and each that is "@file" with those read from file, one
+// per line, so that the list of packages can be computed by other tools.
+// Blank lines and lines starting with # are ignored.
+func expandPaths(args []string, stdin io.Reader) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		switch {
+		case arg == "-":
+			read, err := readPaths(stdin)
+			if err != nil {
+				return nil, fmt.Errorf("reading stdin: %w", err)
+			}
+			paths = append(paths, read...)
+		case strings.HasPrefix(arg, "@"):
+			f, err := os.Open(arg[1:])
+			if err != nil {
+				return nil, err
+			}
+			read, err := readPaths(f)
+			if err != nil {
+				_ = f.Close()
+				return nil, fmt.Errorf("reading %s: %w", arg[1:], err)
+			}
+			if err := f.Close(); err != nil {
+				return nil, err
+			}
+			paths = append(paths, read...)
+		default:
+			paths = append(paths, arg)
+		}
+	}
+	return paths, nil
+}
+
+// readPaths returns the package patterns in r, one per line, skipping blank
+// lines and lines starting with #.
+func readPaths(r io.Reader) ([]string, error) {
+	var paths []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	return paths, scanner.Err()
+}
+
+// parseLineRanges parses a comma-separated list of line ranges, each of which
+// is a file followed by a line or an inclusive range of lines, as in
+// foo.go:12 or foo.go:12-20.
+func parseLineRanges(s string) ([]gcassert.LineRange, error) {
+	var ranges []gcassert.LineRange
+	for _, r := range strings.Split(s, ",") {
+		i := strings.LastIndex(r, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid line range %q, expected file:start-end", r)
+		}
+		first, last, isRange := strings.Cut(r[i+1:], "-")
+		if !isRange {
+			last = first
+		}
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid line range %q, expected file:start-end", r)
+		}
+		end, err := strconv.Atoi(last)
+		if err != nil {
+			return nil, fmt.Errorf("invalid line range %q, expected file:start-end", r)
+		}
+		ranges = append(ranges, gcassert.LineRange{File: r[:i], Start: start, End: end})
 	}
+	return ranges, nil
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandPaths(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "paths.txt")
+	if err := os.WriteFile(file, []byte("./a\n\n# changed packages\n  ./b  \n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		name  string
+		args  []string
+		stdin string
+		paths []string
+	}{
+		{
+			name:  "arguments",
+			args:  []string{"./x", "./y/..."},
+			paths: []string{"./x", "./y/..."},
+		},
+		{
+			name:  "stdin",
+			args:  []string{"./x", "-"},
+			stdin: "./s\n\n   \n# comment\n./t\n",
+			paths: []string{"./x", "./s", "./t"},
+		},
+		{
+			name:  "file",
+			args:  []string{"@" + file, "./x"},
+			paths: []string{"./a", "./b", "./x"},
+		},
+		{
+			name:  "stdin and file",
+			args:  []string{"-", "@" + file},
+			stdin: "./s",
+			paths: []string{"./s", "./a", "./b"},
+		},
+		{
+			name: "empty stdin",
+			args: []string{"-"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			paths, err := expandPaths(testCase.args, strings.NewReader(testCase.stdin))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, testCase.paths, paths)
+		})
+	}
+
+	_, err := expandPaths([]string{"@" + filepath.Join(dir, "missing.txt")}, strings.NewReader(""))
+	assert.Error(t, err)
+}
@@ -2,6 +2,9 @@ package gcassert
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"go/ast"
@@ -13,10 +16,14 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
+	"golang.org/x/tools/go/analysis"
 	"golang.org/x/tools/go/packages"
 )
 
@@ -27,26 +34,285 @@ const (
 	inline
 	bce
 	noescape
+	staticinit
+	nocopy
+	noinline
+	noescapecall
+	noescapeclosure
+	allocs
+	bcemerge
+	wordsize
+	noalloc
+	nospill
+	typeassertmerge
+	nogrow
+	maxtextsize
+	constant
+	inlineeq
+	constfold
+	noselectgo
+	inlinenoalloc
+	staticitab
+	opendefer
+	noretspill
+	mapfaststr
+	register
+	inlinebce
+	nomorestack
+	callfree
+	cost
+	nilcheck
+	stack
+	ssa
+	devirt
+	inlinedeep
+
+	// numDirectives is the number of directives, and must come last.
+	numDirectives
 )
 
+// unsupportedDirectives maps directives that can't be checked, because the
+// compiler doesn't report the decisions that they depend on, to the reason
+// why. These fail clearly rather than as unknown directives.
+var unsupportedDirectives = map[string]string{
+	"hot":   "the Go toolchain doesn't split hot and cold functions into separate text sections",
+	"hoist": "the Go compiler has no loop-invariant code motion pass, so it never hoists computations out of loops",
+	"stackchan": "the Go runtime allocates every channel on the heap with runtime.makechan, even one that doesn't escape; " +
+		"use noalloc to assert that no channel is made",
+	"simd": "the Go compiler doesn't auto-vectorize loops into SIMD instructions, on any architecture",
+	"rodata": "the Go compiler places every package-level variable in writable data, even one that's never written; " +
+		"only the contents of constants, such as string literals, are read-only",
+	"noresize": "the Go compiler doesn't prove that an append in a loop stays within the slice's capacity, " +
+		"and every map assignment may grow the map; use nogrow to assert that an append outside a loop doesn't grow its slice",
+}
+
+func (d assertDirective) String() string {
+	switch d {
+	case inline:
+		return "inline"
+	case bce:
+		return "bce"
+	case noescape:
+		return "noescape"
+	case staticinit:
+		return "staticinit"
+	case nocopy:
+		return "nocopy"
+	case noinline:
+		return "noinline"
+	case noescapecall:
+		return "noescapecall"
+	case noescapeclosure:
+		return "noescapeclosure"
+	case allocs:
+		return "allocs"
+	case bcemerge:
+		return "bcemerge"
+	case wordsize:
+		return "wordsize"
+	case noalloc:
+		return "noalloc"
+	case nospill:
+		return "nospill"
+	case typeassertmerge:
+		return "typeassertmerge"
+	case nogrow:
+		return "nogrow"
+	case maxtextsize:
+		return "maxtextsize"
+	case constant:
+		return "const"
+	case inlineeq:
+		return "inlineeq"
+	case constfold:
+		return "constfold"
+	case noselectgo:
+		return "noselectgo"
+	case inlinenoalloc:
+		return "inlinenoalloc"
+	case staticitab:
+		return "staticitab"
+	case opendefer:
+		return "opendefer"
+	case noretspill:
+		return "noretspill"
+	case mapfaststr:
+		return "mapfaststr"
+	case register:
+		return "register"
+	case inlinebce:
+		return "inlinebce"
+	case nomorestack:
+		return "nomorestack"
+	case callfree:
+		return "callfree"
+	case cost:
+		return "cost"
+	case nilcheck:
+		return "nilcheck"
+	case stack:
+		return "stack"
+	case ssa:
+		return "ssa"
+	case devirt:
+		return "devirt"
+	case inlinedeep:
+		return "inlinedeep"
+	}
+	return ""
+}
+
+// negatedDirectives maps directives to the directive that asserts the reverse,
+// which can be written as the directive with a leading "!", as in !inline.
+var negatedDirectives = map[assertDirective]assertDirective{
+	inline: noinline,
+}
+
 func stringToDirective(s string) (assertDirective, error) {
-	switch s {
-	case "inline":
-		return inline, nil
-	case "bce":
-		return bce, nil
-	case "noescape":
-		return noescape, nil
+	if negated, ok := strings.CutPrefix(s, "!"); ok {
+		directive, err := stringToDirective(negated)
+		if err != nil {
+			return noDirective, err
+		}
+		if reverse, ok := negatedDirectives[directive]; ok {
+			return reverse, nil
+		}
+		return noDirective, errors.New(fmt.Sprintf("directive %q can't be negated", negated))
+	}
+	if reason, ok := unsupportedDirectives[s]; ok {
+		return noDirective, errors.New(fmt.Sprintf("unsupported directive %q: %s", s, reason))
+	}
+	for d := inline; d < numDirectives; d++ {
+		if d.String() == s {
+			return d, nil
+		}
 	}
 	return noDirective, errors.New(fmt.Sprintf("unknown directive %q", s))
 }
 
+// parseDirective parses a directive and its argument, which follows the
+// directive name after a colon or an equals sign, as in allocs:2 or
+// register=sum, or after "<=" for a bound, as in cost<=70.
+func parseDirective(s string) (assertDirective, string, error) {
+	name, arg, hasArg := s, "", false
+	if i := strings.Index(s, "<="); i >= 0 {
+		name, arg, hasArg = s[:i], s[i+2:], true
+	} else if i := strings.IndexAny(s, ":="); i >= 0 {
+		name, arg, hasArg = s[:i], s[i+1:], true
+	}
+	directive, err := stringToDirective(name)
+	if err != nil {
+		return noDirective, "", err
+	}
+	switch directive {
+	case allocs:
+		if _, err := strconv.Atoi(arg); err != nil {
+			return noDirective, "", errors.New(fmt.Sprintf("directive %q requires a number of allocations, such as allocs:2", s))
+		}
+	case maxtextsize:
+		if _, err := strconv.Atoi(arg); err != nil {
+			return noDirective, "", errors.New(fmt.Sprintf("directive %q requires a number of bytes, such as maxtextsize:256", s))
+		}
+	case cost:
+		if _, err := strconv.Atoi(arg); err != nil {
+			return noDirective, "", errors.New(fmt.Sprintf("directive %q requires a bound on the inline cost, such as cost<=70", s))
+		}
+	case register:
+		if !token.IsIdentifier(arg) {
+			return noDirective, "", errors.New(fmt.Sprintf("directive %q requires a variable name, such as register=sum", s))
+		}
+	case inline, noinline:
+		if hasArg && !token.IsIdentifier(arg) {
+			return noDirective, "", errors.New(fmt.Sprintf("directive %q requires a function name, such as inline=addOne", s))
+		}
+	case ssa:
+		if _, ok := ssaPasses[arg]; !ok {
+			passes := make([]string, 0, len(ssaPasses))
+			for pass := range ssaPasses {
+				passes = append(passes, pass)
+			}
+			sort.Strings(passes)
+			return noDirective, "", errors.New(fmt.Sprintf("directive %q requires an SSA pass that reports its optimizations, one of %s, such as ssa=prove",
+				s, strings.Join(passes, ", ")))
+		}
+	default:
+		if hasArg {
+			return noDirective, "", errors.New(fmt.Sprintf("directive %q doesn't take an argument", name))
+		}
+	}
+	return directive, arg, nil
+}
+
+// ssaPasses maps the SSA passes that an ssa directive can name to the prefixes
+// of the messages that each prints about the optimizations it makes, when its
+// debug flag, -d=ssa/<pass>/debug=1, is set. The compiler's other passes don't
+// report what they do at a position in the source.
+var ssaPasses = map[string][]string{
+	// prove removes the bounds checks, nil checks and branches that it
+	// proves are redundant, and finds the induction variables of loops.
+	"prove": {"Proved ", "Disproved ", "Induction variable: "},
+	// phiopt replaces the phi of a branch that only selects a value, such
+	// as a bool converted to an int, with arithmetic.
+	"phiopt": {"converted OpPhi"},
+}
+
+// lineSpanDirectives are the directives that can be applied to a range of
+// lines with a +N suffix, as in bce+3. They're checked against the compiler's
+// messages for each line separately.
+var lineSpanDirectives = map[assertDirective]bool{
+	bce:      true,
+	noescape: true,
+}
+
+// cutLineSpan cuts the +N suffix, which applies a directive to the N lines
+// after the line of the node it's attached to as well, from s.
+func cutLineSpan(s string) (string, int, error) {
+	i := strings.LastIndex(s, "+")
+	if i < 0 {
+		return s, 0, nil
+	}
+	n, err := strconv.Atoi(s[i+1:])
+	if err != nil || n <= 0 {
+		return "", 0, errors.New(fmt.Sprintf("directive %q requires a positive number of lines, such as bce+3", s))
+	}
+	return s[:i], n, nil
+}
+
 // passInfo contains info on a passed directive for directives that have
 // compiler output when they pass, such as the inlining directive.
 type passInfo struct {
 	passed bool
 	// colNo is the column number of the location of the inlineable callsite.
 	colNo int
+	// callee is the name of the called function, as inlinedFuncName returns
+	// it, which is checked against the compiler's message as well as colNo,
+	// since generated code's //line directives can give several calls the
+	// same position. It's empty for callfree callsites, which pass if any
+	// call at the column is inlined.
+	callee string
+	// call is the call at the callsite, which is reported rather than the
+	// whole line when it fails, since there can be several calls on a line.
+	call *ast.CallExpr
+	// noinline is true if the callee was marked with //gcassert:noinline
+	// rather than //gcassert:inline, in which case the callsite fails if
+	// passed is set.
+	noinline bool
+	// viaInterface is true if the callee is called through an interface, so
+	// that it must be devirtualized before it can be inlined.
+	viaInterface bool
+	// callfree is true if the call is in the body of a loop with a callfree
+	// directive, rather than to a function with an inline directive.
+	callfree bool
+	// stmt is "defer" or "go" if the call is the call of a defer or go
+	// statement, which the compiler inlines into the wrapper function that
+	// it generates for the statement rather than into the caller.
+	stmt string
+	// deep is the callee, if it was marked with //gcassert:inlinedeep, in
+	// which case nested are the names of the inline-asserted functions that
+	// it calls, which must be inlined at the callsite too, and inlined are
+	// the names of the functions that the compiler inlined at the callsite.
+	deep            types.Object
+	nested, inlined []string
 }
 
 type lineInfo struct {
@@ -57,12 +323,131 @@ type lineInfo struct {
 	// passedDirective is a map from index into the directives slice to a
 	// boolean that says whether or not the directive succeeded, in the case
 	// of directives like inlining that have compiler output if they passed.
-	// For directives like bce that have compiler output if they failed, there's
-	// no entry in this map.
+	// For noinline, an entry records that the compiler inlined a call on the
+	// line, which fails the directive. For directives like bce that have
+	// compiler output if they failed, there's no entry in this map.
 	passedDirective map[int]bool
+	// args is a map from index into the directives slice to the directive's
+	// argument, for directives like allocs:N that take one.
+	args map[int]string
+	// interfaceCall is true if the node has an inline directive and calls a
+	// method through an interface, and devirtualized is true if the compiler
+	// devirtualized a call on the line, which it must do before it can
+	// inline such a call.
+	interfaceCall bool
+	devirtualized bool
+}
+
+// has returns whether the line is annotated with directive d.
+func (info lineInfo) has(d assertDirective) bool {
+	for _, directive := range info.directives {
+		if directive == d {
+			return true
+		}
+	}
+	return false
+}
+
+// notInlinedFailure returns the failure message for an inline directive or
+// callsite on the line described by info that wasn't inlined, explaining
+// whether a call through an interface wasn't devirtualized, or whether the
+// call is that of a defer or go statement, given by stmt.
+func notInlinedFailure(info lineInfo, viaInterface bool, stmt string) string {
+	if viaInterface && !info.devirtualized {
+		return "interface call was not devirtualized, so it was not inlined"
+	}
+	if stmt != "" {
+		return fmt.Sprintf("call in %s statement was not inlined into its wrapper", stmt)
+	}
+	return "call was not inlined"
+}
+
+// inlinedFuncName returns the name of the function or method that the
+// compiler's "inlining call to" message names as callee, without its package,
+// receiver type or type arguments, as in add for pair[go.shape.int].add.
+func inlinedFuncName(callee string) string {
+	var b strings.Builder
+	depth := 0
+	for _, c := range callee {
+		switch {
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case depth == 0:
+			b.WriteRune(c)
+		}
+	}
+	name := b.String()
+	return name[strings.LastIndex(name, ".")+1:]
 }
 
-var gcAssertRegex = regexp.MustCompile(`// ?gcassert:([\w,]+)`)
+// gcAssertRegex matches a directive comment. Spaces are allowed after the
+// colon and around the commas that separate the directives, which are
+// trimmed from each of them.
+var gcAssertRegex = regexp.MustCompile(`// ?gcassert: *([\w:=!<+]+(?: *, *[\w:=!<+]+)*)`)
+
+// optInfo matches a message that the compiler printed about a position, and
+// captures its path, line, column and the message itself. On Windows, the
+// path has backslashes, and may start with a drive letter, as in
+// C:\src\foo.go:10:5. The path of a file in the module cache has the
+// module's version, after an @, and its uppercase letters escaped with a !.
+var optInfo = regexp.MustCompile(`((?:[A-Za-z]:)?[\w.\/\\@!~+-]+):(\d+):(\d+): (.*)`)
+
+// escapeMessage matches the escape analysis messages that don't explain the
+// flow of a previous message.
+var escapeMessage = regexp.MustCompile(`escapes to heap|does not escape|moved to heap|leaking param|leaks to`)
+
+// nearDirectiveRegex matches comments that start like a directive, with any
+// spelling of gcassert followed by a colon, and so were probably meant to be
+// directives. Other comments that mention gcassert are prose.
+var nearDirectiveRegex = regexp.MustCompile(`(?i)^//\s*gc[-_ ]?assert\s*:`)
+
+// checkMalformedDirectives fails every comment in pkgs that looks like it was
+// meant to be a //gcassert directive, but either doesn't match
+// gcAssertRegex, or has text after the directives that would be ignored.
+func checkMalformedDirectives(pkgs []*packages.Package, r *reporter) {
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Syntax {
+			for _, g := range file.Comments {
+				for _, c := range g.List {
+					if !nearDirectiveRegex.MatchString(c.Text) {
+						continue
+					}
+					loc := gcAssertRegex.FindStringIndex(c.Text)
+					if loc != nil && loc[0] == 0 {
+						rest := strings.TrimSpace(c.Text[loc[1]:])
+						if rest == "" || strings.HasPrefix(rest, "//") {
+							continue
+						}
+					}
+					r.fail(c, noDirective,
+						"malformed directive comment, expected a comma-separated list of directives such as //gcassert:inline,bce")
+				}
+			}
+		}
+	}
+}
+
+// checkUncalledInlineFuncs fails the inline and inlinedeep directives of the
+// functions in uncalled, which are never called in pkgs, so their directives
+// pass without being checked. This usually means that the packages that call
+// them weren't loaded.
+func checkUncalledInlineFuncs(pkgs []*packages.Package, uncalled map[types.Object]assertDirective, r *reporter) {
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Syntax {
+			for _, decl := range file.Decls {
+				fd, ok := decl.(*ast.FuncDecl)
+				if !ok {
+					continue
+				}
+				if d, ok := uncalled[pkg.TypesInfo.Defs[fd.Name]]; ok {
+					r.fail(fd, d, "function is never called in the checked packages")
+				}
+			}
+		}
+	}
+}
 
 type assertVisitor struct {
 	commentMap ast.CommentMap
@@ -72,33 +457,35 @@ type assertVisitor struct {
 	// parsed.
 	directiveMap map[int]lineInfo
 
-	// mustInlineFuncs is a set of types.Objects that represent FuncDecls of
-	// some kind that were marked with //gcassert:inline by the user.
-	mustInlineFuncs map[types.Object]struct{}
-	fileSet         *token.FileSet
-	cwd             string
+	// inlineFuncs maps types.Objects that represent FuncDecls of some kind
+	// that were marked with //gcassert:inline or //gcassert:noinline by the
+	// user to that directive.
+	inlineFuncs map[types.Object]assertDirective
+	fileSet     *token.FileSet
 
 	p *packages.Package
 
-	errOutput io.Writer
+	r *reporter
+
+	// external maps line numbers to the directives read from a directives
+	// file for those lines, which haven't been attached to a node yet.
+	external map[int]string
 }
 
 func newAssertVisitor(
 	commentMap ast.CommentMap,
 	fileSet *token.FileSet,
-	cwd string,
 	p *packages.Package,
-	mustInlineFuncs map[types.Object]struct{},
-	errOutput io.Writer,
+	inlineFuncs map[types.Object]assertDirective,
+	r *reporter,
 ) assertVisitor {
 	return assertVisitor{
-		commentMap:      commentMap,
-		fileSet:         fileSet,
-		cwd:             cwd,
-		directiveMap:    make(map[int]lineInfo),
-		mustInlineFuncs: mustInlineFuncs,
-		p:               p,
-		errOutput:       errOutput,
+		commentMap:   commentMap,
+		fileSet:      fileSet,
+		directiveMap: make(map[int]lineInfo),
+		inlineFuncs:  inlineFuncs,
+		p:            p,
+		r:            r,
 	}
 }
 
@@ -117,260 +504,3502 @@ func (v *assertVisitor) Visit(node ast.Node) ast.Visitor {
 			}
 			// The 0th match is the whole string, and the 1st match is the
 			// gcassert directive(s).
-			directiveStrings := strings.Split(matches[1], ",")
-
-			lineInfo := v.directiveMap[pos.Line]
-			lineInfo.n = node
-			for _, s := range directiveStrings {
-				directive, err := stringToDirective(s)
-				if err != nil {
-					printAssertionFailure(v.cwd, v.fileSet, node, v.errOutput, err.Error())
-					continue
-				}
-				if directive == inline {
-					switch n := node.(type) {
-					case *ast.FuncDecl:
-						// Add the Object that this FuncDecl's ident is connected
-						// to our map of must-inline functions.
-						obj := v.p.TypesInfo.Defs[n.Name]
-						if obj != nil {
-							v.mustInlineFuncs[obj] = struct{}{}
-						}
-						continue
-					}
-				}
-				lineInfo.directives = append(lineInfo.directives, directive)
-				v.directiveMap[pos.Line] = lineInfo
-			}
+			v.addDirectives(node, pos, c, strings.Split(matches[1], ","))
 		}
 	}
+	if directives, ok := v.external[pos.Line]; ok {
+		// External directives are attached to the first node visited on
+		// their line, which is the outermost, like a comment on the line.
+		delete(v.external, pos.Line)
+		v.addDirectives(node, pos, node, strings.Split(directives, ","))
+	}
 	return v
 }
 
-// GCAssert searches through the packages at the input path and writes failures
-// to comply with //gcassert directives to the given io.Writer.
-func GCAssert(w io.Writer, paths ...string) error {
-	return GCAssertCwd(w, "", paths...)
-}
-
-// GCAssertCwd performs the same operation as GCAssert, but runs `go build` in
-// the provided working directory `cwd`. If `cwd` is the empty string, then
-// `go build` will be run in the current working directory.
-func GCAssertCwd(w io.Writer, cwd string, paths ...string) error {
-	var err error
-	if cwd == "" {
-		cwd, err = os.Getwd()
+// addDirectives parses directiveStrings, the directives attached to node at
+// pos, and records them. A directive that can't be parsed, or can't be
+// applied to node, is reported against source, the comment that it was read
+// from, rather than against node, which may be a whole function.
+func (v *assertVisitor) addDirectives(node ast.Node, pos token.Position, source ast.Node, directiveStrings []string) {
+	lineInfo := v.directiveMap[pos.Line]
+	lineInfo.n = node
+	for _, s := range directiveStrings {
+		s, span, err := cutLineSpan(strings.TrimSpace(s))
 		if err != nil {
-			return err
+			v.r.fail(source, noDirective, err.Error())
+			continue
 		}
-	}
-
-	fileSet := token.NewFileSet()
-	pkgs, err := packages.Load(&packages.Config{
-		Dir: cwd,
-		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedCompiledGoFiles |
-			packages.NeedTypesInfo | packages.NeedTypes,
-		Fset: fileSet,
-	}, paths...)
-	directiveMap, err := parseDirectives(pkgs, fileSet, cwd, w)
-	if err != nil {
-		return err
-	}
-
-	// Next: invoke Go compiler with -m flags to get the compiler to print
-	// its optimization decisions.
-
-	args := []string{"build", "-gcflags=-m=2 -d=ssa/check_bce/debug=1"}
-	for i := range paths {
-		if filepath.IsAbs(paths[i]) {
-			args = append(args, paths[i])
-		} else {
-			args = append(args, "./"+paths[i])
+		directive, arg, err := parseDirective(s)
+		if err != nil {
+			v.r.fail(source, noDirective, err.Error())
+			continue
 		}
-	}
-	cmd := exec.Command("go", args...)
-	cmd.Dir = cwd
-	pr, pw := io.Pipe()
-	// Create a temp file to log all diagnostic output.
-	f, err := os.CreateTemp("", "gcassert-*.log")
-	if err != nil {
-		return err
-	}
-	fmt.Printf("See %s for full output.\n", f.Name())
-	// Log full 'go build' command.
-	fmt.Fprintln(f, cmd)
-	mw := io.MultiWriter(pw, f)
-	cmd.Stdout = mw
-	cmd.Stderr = mw
-	cmdErr := make(chan error, 1)
-
-	go func() {
-		cmdErr <- cmd.Run()
-		_ = pw.Close()
-		_ = f.Close()
-	}()
-
-	scanner := bufio.NewScanner(pr)
-	optInfo := regexp.MustCompile(`([\.\/\w]+):(\d+):(\d+): (.*)`)
-	boundsCheck := "Found IsInBounds"
-	sliceBoundsCheck := "Found IsSliceInBounds"
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		matches := optInfo.FindStringSubmatch(line)
-		if len(matches) != 0 {
-			path := matches[1]
-			lineNo, err := strconv.Atoi(matches[2])
-			if err != nil {
-				return err
-			}
-			colNo, err := strconv.Atoi(matches[3])
-			if err != nil {
-				return err
+		if span > 0 {
+			if !lineSpanDirectives[directive] {
+				v.r.fail(source, directive, fmt.Sprintf("directive %q can't be applied to a range of lines", s))
+				continue
 			}
-			message := matches[4]
-
-			if !filepath.IsAbs(path) {
-				path = filepath.Join(cwd, path)
+			v.addLineSpan(node, directive, pos.Line+1, pos.Line+span)
+		}
+		if lineSpanDirectives[directive] && lineInfo.has(directive) {
+			// The line is already covered by a range of lines with
+			// the same directive, so only its node is recorded.
+			v.directiveMap[pos.Line] = lineInfo
+			continue
+		}
+		if directive == inlinedeep {
+			if _, ok := node.(*ast.FuncDecl); !ok {
+				v.r.fail(source, directive, "directive must be attached to a function")
+				continue
 			}
-			if lineToDirectives := directiveMap[path]; lineToDirectives != nil {
-				info := lineToDirectives[lineNo]
-				if len(info.directives) > 0 {
-					if info.passedDirective == nil {
-						info.passedDirective = make(map[int]bool)
-						lineToDirectives[lineNo] = info
-					}
-				}
-				for i, d := range info.directives {
-					switch d {
-					case bce:
-						if message == boundsCheck || message == sliceBoundsCheck {
-							// Error! We found a bounds check where the user expected
-							// there to be none.
-							// Print out the user's code lineNo that failed the assertion,
-							// the assertion itself, and the compiler output that
-							// proved that the assertion failed.
-							printAssertionFailure(cwd, fileSet, info.n, w, message)
-						}
-					case inline:
-						if strings.HasPrefix(message, "inlining call to") {
-							info.passedDirective[i] = true
-						}
-					case noescape:
-						if strings.HasSuffix(message, "escapes to heap:") {
-							printAssertionFailure(cwd, fileSet, info.n, w, message)
-						}
-						if strings.Contains(message, "leaking param:") {
-							printAssertionFailure(cwd, fileSet, info.n, w, message)
-						}
-					}
+		}
+		if directive == inline || directive == noinline || directive == inlinedeep {
+			switch n := node.(type) {
+			case *ast.FuncDecl:
+				if arg != "" {
+					v.r.fail(source, directive, fmt.Sprintf("directive %q on a function can't name a callee", s))
+					continue
 				}
-				for i := range info.inlinableCallsites {
-					cs := &info.inlinableCallsites[i]
-					if cs.colNo == colNo {
-						cs.passed = true
-					}
+				// Add the Object that this FuncDecl's ident is connected
+				// to our map of inline-asserted functions.
+				obj := v.p.TypesInfo.Defs[n.Name]
+				if obj != nil {
+					v.inlineFuncs[obj] = directive
 				}
+				continue
+			}
+		}
+		if directive == inline && arg == "" && v.onlyCallsFuncField(node) {
+			// The compiler can't inline an indirect call, so
+			// rather than failing with "call was not inlined",
+			// explain why. Other calls on the line are checked as
+			// usual.
+			v.r.fail(node, directive, "indirect call through function field cannot be inlined")
+			continue
+		}
+		if directive == inline && v.callsInterfaceMethod(node) {
+			lineInfo.interfaceCall = true
+		}
+		if arg != "" {
+			if lineInfo.args == nil {
+				lineInfo.args = make(map[int]string)
 			}
+			lineInfo.args[len(lineInfo.directives)] = arg
+		}
+		lineInfo.directives = append(lineInfo.directives, directive)
+		v.directiveMap[pos.Line] = lineInfo
+		switch directive {
+		case wordsize:
+			v.checkWordSize(node)
+		case constant:
+			v.checkConstant(node)
+		case register:
+			v.checkRegisterVar(node, arg)
+		case callfree:
+			v.addLoopCallsites(node)
 		}
 	}
+}
 
-	keys := make([]string, 0, len(directiveMap))
-	for k := range directiveMap {
-		keys = append(keys, k)
+// addLineSpan applies directive d, which is attached to node, to lines start
+// to end as well. The failures on each line are reported against the first
+// statement that starts on it, or node if there's none. A line that has the
+// same directive of its own is only checked once, and its own node is
+// reported.
+func (v *assertVisitor) addLineSpan(node ast.Node, d assertDirective, start, end int) {
+	var file *ast.File
+	for _, f := range v.p.Syntax {
+		if f.Pos() <= node.Pos() && node.End() <= f.End() {
+			file = f
+		}
 	}
-	sort.Strings(keys)
-
-	var lines []int
-	for _, k := range keys {
-		lines = lines[:0]
-		lineToDirectives := directiveMap[k]
-		for line := range lineToDirectives {
-			lines = append(lines, line)
+	for l := start; l <= end; l++ {
+		info := v.directiveMap[l]
+		if info.has(d) {
+			continue
 		}
-		sort.Ints(lines)
-		for _, line := range lines {
-			info := lineToDirectives[line]
-			for _, d := range info.inlinableCallsites {
-				// An inlining directive passes if it has compiler output. For
-				// each inlining directive, check if there was matching compiler
-				// output and fail if not.
-				if !d.passed {
-					printAssertionFailure(cwd, fileSet, info.n, w, "call was not inlined")
-				}
-			}
-			for i, d := range info.directives {
-				if d != inline {
-					continue
+		if info.n == nil && file != nil {
+			ast.Inspect(file, func(n ast.Node) bool {
+				if info.n != nil || n == nil || v.fileSet.Position(n.Pos()).Line > l {
+					return false
 				}
-				if !info.passedDirective[i] {
-					printAssertionFailure(cwd, fileSet, info.n, w, "call was not inlined")
+				if _, ok := n.(ast.Stmt); ok && v.fileSet.Position(n.Pos()).Line == l {
+					if _, ok := n.(*ast.BlockStmt); !ok {
+						info.n = n
+					}
 				}
-			}
+				return info.n == nil
+			})
 		}
+		if info.n == nil {
+			info.n = node
+		}
+		info.directives = append(info.directives, d)
+		v.directiveMap[l] = info
 	}
-	// If 'go build' failed, return the error.
-	if err := <-cmdErr; err != nil {
-		return err
-	}
-	return nil
 }
 
-func printAssertionFailure(cwd string, fileSet *token.FileSet, n ast.Node, w io.Writer, message string) {
-	var buf strings.Builder
-	_ = printer.Fprint(&buf, fileSet, n)
-	pos := fileSet.Position(n.Pos())
-	relPath, err := filepath.Rel(cwd, pos.Filename)
-	if err != nil {
-		relPath = pos.Filename
-	}
-	fmt.Fprintf(w, "%s:%d:\t%s: %s\n", relPath, pos.Line, buf.String(), message)
+// onlyCallsFuncField returns whether the only function call in node is of a
+// function stored in a struct field, such as an entry in a dispatch table.
+// Calls to builtins and conversions aren't function calls.
+func (v *assertVisitor) onlyCallsFuncField(node ast.Node) bool {
+	calls, fieldCalls := 0, 0
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.CallExpr:
+			if tv, ok := v.p.TypesInfo.Types[n.Fun]; ok && (tv.IsType() || tv.IsBuiltin()) {
+				break
+			}
+			calls++
+			sel, ok := n.Fun.(*ast.SelectorExpr)
+			if !ok {
+				break
+			}
+			if selection := v.p.TypesInfo.Selections[sel]; selection != nil && selection.Kind() == types.FieldVal {
+				fieldCalls++
+			}
+		}
+		return true
+	})
+	return calls == 1 && fieldCalls == 1
 }
 
-// directiveMap maps filepath to line number to lineInfo
-type directiveMap map[string]map[int]lineInfo
-
-func parseDirectives(pkgs []*packages.Package, fileSet *token.FileSet, cwd string, errOutput io.Writer) (directiveMap, error) {
-	fileDirectiveMap := make(directiveMap)
-	mustInlineFuncs := make(map[types.Object]struct{})
-	for _, pkg := range pkgs {
-		for i, file := range pkg.Syntax {
-			commentMap := ast.NewCommentMap(fileSet, file, file.Comments)
-
-			v := newAssertVisitor(commentMap, fileSet, cwd, pkg, mustInlineFuncs, errOutput)
-			// First: find all lines of code annotated with our gcassert directives.
-			ast.Walk(&v, file)
-
-			file := pkg.CompiledGoFiles[i]
-			if len(v.directiveMap) > 0 {
-				fileDirectiveMap[file] = v.directiveMap
+// addLoopCallsites records each function call in the body of the loop that
+// node is, which must be inlined for the loop's callfree directive to pass.
+// Calls to builtins and conversions aren't function calls, and any runtime
+// calls that they compile to are found in the assembly listing instead.
+func (v *assertVisitor) addLoopCallsites(node ast.Node) {
+	var body *ast.BlockStmt
+	switch n := node.(type) {
+	case *ast.ForStmt:
+		body = n.Body
+	case *ast.RangeStmt:
+		body = n.Body
+	default:
+		v.r.fail(node, callfree, "directive must be attached to a loop")
+		return
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.CallExpr:
+			if tv, ok := v.p.TypesInfo.Types[n.Fun]; ok && (tv.IsType() || tv.IsBuiltin()) {
+				break
+			}
+			line := v.fileSet.Position(n.Pos()).Line
+			lineInfo := v.directiveMap[line]
+			if lineInfo.n == nil {
+				lineInfo.n = n
 			}
+			lineInfo.inlinableCallsites = append(lineInfo.inlinableCallsites, passInfo{
+				colNo:    v.fileSet.Position(n.Lparen).Column,
+				call:     n,
+				callfree: true,
+			})
+			v.directiveMap[line] = lineInfo
 		}
-	}
+		return true
+	})
+}
 
-	// Do another pass to find all callsites of funcs marked with inline.
-	for _, pkg := range pkgs {
-		for i, file := range pkg.Syntax {
-			v := &inlinedDeclVisitor{assertVisitor: newAssertVisitor(nil, fileSet, cwd, pkg, mustInlineFuncs, errOutput)}
-			filePath := pkg.CompiledGoFiles[i]
-			v.directiveMap = fileDirectiveMap[filePath]
-			if v.directiveMap == nil {
-				v.directiveMap = make(map[int]lineInfo)
+// callsInterfaceMethod returns whether node calls a method through an
+// interface.
+func (v *assertVisitor) callsInterfaceMethod(node ast.Node) bool {
+	found := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.CallExpr:
+			sel, ok := n.Fun.(*ast.SelectorExpr)
+			if !ok {
+				break
 			}
-			ast.Walk(v, file)
-			if len(v.directiveMap) > 0 {
-				fileDirectiveMap[filePath] = v.directiveMap
+			if selection := v.p.TypesInfo.Selections[sel]; selection != nil &&
+				selection.Kind() == types.MethodVal && types.IsInterface(selection.Recv()) {
+				found = true
 			}
 		}
-	}
-	return fileDirectiveMap, nil
+		return !found
+	})
+	return found
 }
 
-type inlinedDeclVisitor struct {
-	assertVisitor
+// checkConstant fails the const directive attached to node unless the values
+// that node assigns or declares are compile-time constants.
+func (v *assertVisitor) checkConstant(node ast.Node) {
+	var exprs []ast.Expr
+	switch n := node.(type) {
+	case *ast.AssignStmt:
+		exprs = n.Rhs
+	case *ast.ValueSpec:
+		exprs = n.Values
+	case *ast.GenDecl:
+		for _, spec := range n.Specs {
+			if spec, ok := spec.(*ast.ValueSpec); ok {
+				exprs = append(exprs, spec.Values...)
+			}
+		}
+	case *ast.ReturnStmt:
+		exprs = n.Results
+	case *ast.ExprStmt:
+		exprs = append(exprs, n.X)
+	case ast.Expr:
+		exprs = append(exprs, n)
+	}
+	if len(exprs) == 0 {
+		v.r.fail(node, constant, "directive must be attached to an expression")
+		return
+	}
+	for _, expr := range exprs {
+		if v.p.TypesInfo.Types[expr].Value == nil {
+			v.r.fail(expr, constant, "expression is not a compile-time constant")
+		}
+	}
 }
 
-func (v *inlinedDeclVisitor) Visit(node ast.Node) ast.Visitor {
+// checkWordSize fails the wordsize directive attached to node unless every
+// type that node declares fits in a single machine word of the target
+// architecture. This only needs type information, so it's checked without
+// consulting the compiler.
+func (v *assertVisitor) checkWordSize(node ast.Node) {
+	var specs []*ast.TypeSpec
+	switch n := node.(type) {
+	case *ast.GenDecl:
+		for _, spec := range n.Specs {
+			if spec, ok := spec.(*ast.TypeSpec); ok {
+				specs = append(specs, spec)
+			}
+		}
+	case *ast.TypeSpec:
+		specs = append(specs, n)
+	}
+	if len(specs) == 0 {
+		v.r.fail(node, wordsize, "directive must be attached to a type declaration")
+		return
+	}
+	word := v.p.TypesSizes.Sizeof(types.Typ[types.Uintptr])
+	for _, spec := range specs {
+		if spec.TypeParams != nil {
+			v.r.fail(spec, wordsize, "the size of a generic type depends on its type arguments")
+			continue
+		}
+		obj := v.p.TypesInfo.Defs[spec.Name]
+		if obj == nil {
+			continue
+		}
+		if size := v.p.TypesSizes.Sizeof(obj.Type()); size > word {
+			v.r.fail(spec, wordsize, fmt.Sprintf("type is %d bytes, larger than the %d byte machine word", size, word))
+		}
+	}
+}
+
+// checkRegisterVar fails the register directive attached to node unless node
+// declares a variable named name, so that a misspelled name doesn't pass
+// because there's nothing to spill.
+func (v *assertVisitor) checkRegisterVar(node ast.Node, name string) {
+	found := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Name == name {
+			if _, ok := v.p.TypesInfo.Defs[id].(*types.Var); ok {
+				found = true
+			}
+		}
+		return !found
+	})
+	if !found {
+		v.r.fail(node, register, fmt.Sprintf("no variable named %s is declared here", name))
+	}
+}
+
+// GCAssert searches through the packages at the input path and writes failures
+// to comply with //gcassert directives to the given io.Writer. It returns
+// ErrAssertionsFailed if any directive fails, and another error if no paths
+// are given, or if any of the packages can't be loaded.
+func GCAssert(w io.Writer, paths ...string) error {
+	return GCAssertCwd(w, "", paths...)
+}
+
+// GCAssertCwd performs the same operation as GCAssert, but runs `go build` in
+// the provided working directory `cwd`. If `cwd` is the empty string, then
+// `go build` will be run in the current working directory.
+func GCAssertCwd(w io.Writer, cwd string, paths ...string) error {
+	return GCAssertWithOptions(w, Options{Cwd: cwd}, paths...)
+}
+
+// GCAssertResults performs the same operation as GCAssert, but returns the
+// failures instead of writing them, so that other tools can consume them.
+func GCAssertResults(paths ...string) ([]Failure, error) {
+	return GCAssertResultsWithOptions(Options{}, paths...)
+}
+
+// GCAssertResultsWithOptions performs the same operation as GCAssertResults,
+// but allows the build to be configured with opts. The failures of directives
+// that aren't in opts.FailOn are returned with Warning set.
+func GCAssertResultsWithOptions(opts Options, paths ...string) ([]Failure, error) {
+	failures, _, err := run(opts, paths...)
+	return failures, err
+}
+
+// GCAssertJSON performs the same operation as GCAssert, but writes each
+// failure to w as a JSON object on its own line, for tools to parse.
+func GCAssertJSON(w io.Writer, paths ...string) error {
+	return GCAssertJSONCwd(w, "", paths...)
+}
+
+// GCAssertJSONCwd performs the same operation as GCAssertJSON, but runs `go
+// build` in the provided working directory `cwd`, like GCAssertCwd.
+func GCAssertJSONCwd(w io.Writer, cwd string, paths ...string) error {
+	opts := Options{Cwd: cwd}
+	failures, _, err := run(opts, paths...)
+	enc := json.NewEncoder(w)
+	for _, f := range failures {
+		if encErr := enc.Encode(f); encErr != nil {
+			return encErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return opts.failed(failures)
+}
+
+// GCAssertSARIF performs the same operation as GCAssert, but writes the
+// failures to w as a SARIF 2.1.0 log, which code scanning tools such as
+// GitHub's can show as annotations. Each failure is a result of the rule for
+// its directive, such as gcassert/bce.
+func GCAssertSARIF(w io.Writer, paths ...string) error {
+	return GCAssertSARIFWithOptions(w, Options{}, paths...)
+}
+
+// GCAssertSARIFWithOptions performs the same operation as GCAssertSARIF, but
+// allows the build to be configured with opts. The failures of directives
+// that aren't in opts.FailOn are written with the level "warning", rather
+// than "error".
+func GCAssertSARIFWithOptions(w io.Writer, opts Options, paths ...string) error {
+	failures, _, err := run(opts, paths...)
+	if encErr := writeSARIF(w, failures); encErr != nil {
+		return encErr
+	}
+	if err != nil {
+		return err
+	}
+	return opts.failed(failures)
+}
+
+// Analyzer checks the //gcassert directives of a package, for drivers that
+// run analyzers, such as golangci-lint's module plugins. The directives
+// depend on the compiler's decisions, which analysis passes don't provide, so
+// the Analyzer builds each package with `go build` in the directory of its
+// files, and reports each failure as a diagnostic at its position, whose
+// category is the directive. Packages with _test.go files are skipped, since
+// `go build` doesn't build them, and their other files are checked along with
+// the package without them.
+var Analyzer = &analysis.Analyzer{
+	Name: "gcassert",
+	Doc:  "check //gcassert directives against the compiler's inlining, bounds check and escape decisions",
+	URL:  "https://github.com/fmstephe/gcassert",
+	Run:  runAnalyzer,
+}
+
+func runAnalyzer(pass *analysis.Pass) (interface{}, error) {
+	if len(pass.Files) == 0 {
+		return nil, nil
+	}
+	files := make(map[string]*token.File, len(pass.Files))
+	compiled := make([]string, 0, len(pass.Files))
+	for _, file := range pass.Files {
+		tf := pass.Fset.File(file.Pos())
+		if strings.HasSuffix(tf.Name(), "_test.go") {
+			return nil, nil
+		}
+		files[tf.Name()] = tf
+		compiled = append(compiled, tf.Name())
+	}
+	pkg := &packages.Package{
+		ID:              pass.Pkg.Path(),
+		Name:            pass.Pkg.Name(),
+		PkgPath:         pass.Pkg.Path(),
+		CompiledGoFiles: compiled,
+		Syntax:          pass.Files,
+		Types:           pass.Pkg,
+		TypesInfo:       pass.TypesInfo,
+		TypesSizes:      pass.TypesSizes,
+		Fset:            pass.Fset,
+	}
+	dir := filepath.Dir(compiled[0])
+	failures, _, err := check(Options{AbsPaths: true}, dir, pass.Fset, []*packages.Package{pkg}, dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range failures {
+		tf, ok := files[f.File]
+		if !ok {
+			continue
+		}
+		message := f.Message
+		if f.Directive != "" {
+			message = f.Directive + ": " + message
+		}
+		pass.Report(analysis.Diagnostic{
+			Pos:      tf.LineStart(f.Line) + token.Pos(f.Col-1),
+			Category: f.Directive,
+			Message:  message,
+		})
+	}
+	return nil, nil
+}
+
+// The types below are the subset of the SARIF 2.1.0 format that
+// GCAssertSARIF writes.
+type (
+	sarifLog struct {
+		Version string     `json:"version"`
+		Schema  string     `json:"$schema"`
+		Runs    []sarifRun `json:"runs"`
+	}
+	sarifRun struct {
+		Tool    sarifTool     `json:"tool"`
+		Results []sarifResult `json:"results"`
+	}
+	sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+	sarifDriver struct {
+		Name           string      `json:"name"`
+		InformationURI string      `json:"informationUri"`
+		Rules          []sarifRule `json:"rules"`
+	}
+	sarifRule struct {
+		ID               string       `json:"id"`
+		ShortDescription sarifMessage `json:"shortDescription"`
+	}
+	sarifResult struct {
+		RuleID    string          `json:"ruleId"`
+		Level     string          `json:"level"`
+		Message   sarifMessage    `json:"message"`
+		Locations []sarifLocation `json:"locations"`
+	}
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+	sarifLocation struct {
+		PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	}
+	sarifPhysicalLocation struct {
+		ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+		Region           sarifRegion           `json:"region"`
+	}
+	sarifArtifactLocation struct {
+		URI string `json:"uri"`
+	}
+	sarifRegion struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn"`
+	}
+)
+
+// sarifRuleID returns the ID of the SARIF rule for failures of directive, or
+// of malformed directives if it's empty.
+func sarifRuleID(directive string) string {
+	if directive == "" {
+		return "gcassert/malformed"
+	}
+	return "gcassert/" + directive
+}
+
+// writeSARIF writes failures to w as a SARIF log with a single run.
+func writeSARIF(w io.Writer, failures []Failure) error {
+	sarif := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "gcassert",
+			InformationURI: "https://github.com/fmstephe/gcassert",
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+	seen := make(map[string]bool)
+	for _, f := range failures {
+		id := sarifRuleID(f.Directive)
+		if !seen[id] {
+			seen[id] = true
+			description := fmt.Sprintf("The //gcassert:%s directive wasn't upheld by the compiler", f.Directive)
+			if f.Directive == "" {
+				description = "A //gcassert directive couldn't be parsed"
+			}
+			sarif.Tool.Driver.Rules = append(sarif.Tool.Driver.Rules, sarifRule{
+				ID:               id,
+				ShortDescription: sarifMessage{Text: description},
+			})
+		}
+		level := "error"
+		if f.Warning {
+			level = "warning"
+		}
+		sarif.Results = append(sarif.Results, sarifResult{
+			RuleID:  id,
+			Level:   level,
+			Message: sarifMessage{Text: f.Message},
+			Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{URI: filepath.ToSlash(f.File)},
+				Region:           sarifRegion{StartLine: f.Line, StartColumn: f.Col},
+			}}},
+		})
+	}
+	sort.Slice(sarif.Tool.Driver.Rules, func(i, j int) bool {
+		return sarif.Tool.Driver.Rules[i].ID < sarif.Tool.Driver.Rules[j].ID
+	})
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{sarif},
+	})
+}
+
+// Options configures how GCAssertWithOptions loads and builds packages. The
+// zero value behaves like GCAssert.
+type Options struct {
+	// Cwd is the working directory that `go build` is run in. If it is the
+	// empty string, the current working directory is used.
+	Cwd string
+	// Race builds the packages with the race detector enabled. The race
+	// instrumentation changes the compiler's inlining and bounds check
+	// decisions, so many directives that hold in a normal build will
+	// legitimately fail under -race.
+	Race bool
+	// Toolchains lists Go toolchains, such as "go1.21.0", to check the
+	// directives against. The packages are loaded and built once per
+	// toolchain by setting GOTOOLCHAIN, and each failure is prefixed with
+	// the toolchain that produced it. If empty, the go command's default
+	// toolchain is used.
+	Toolchains []string
+	// StrictDirectives fails on comments that look like they were meant to
+	// be //gcassert directives, but are malformed, such as "//gcassert
+	// inline" or "//gc-assert:inline". Without it, such comments are
+	// ignored, which silently disables the intended assertion. It also fails
+	// on bce and noescape directives that the compiler printed nothing about,
+	// and whose nodes have no index expression or value that could escape,
+	// which are probably attached to the wrong line, and on inline
+	// directives on functions that are never called in the checked packages.
+	StrictDirectives bool
+	// RewriteMessage, if set, is called with the directive and message of
+	// each failure before it's reported, and returns the message to report
+	// instead. This can normalize the compiler's messages, or add
+	// explanations to them. The directive is empty for a failure to parse a
+	// directive. It may be called concurrently.
+	RewriteMessage func(directive, message string) string
+	// EscapeTrace adds every escape analysis message for the function that
+	// contains a failed noescape directive to the failure's Trace, to explain
+	// the escape in the context of the rest of the function.
+	EscapeTrace bool
+	// Lines, if not empty, restricts the failures that are reported to those
+	// of directives attached to nodes that overlap one of the ranges, such
+	// as the lines changed by a pull request. This gates new optimization
+	// regressions without having to fix existing failures first.
+	Lines []LineRange
+	// GCFlags are appended to the -gcflags that the packages are built with,
+	// to check the directives against a build with custom compiler flags,
+	// such as "-B" to disable bounds checks. A flag overrides an earlier
+	// flag of the same name, so the defaults, "-m=2" and
+	// "-d=ssa/check_bce/debug=1", can be overridden, but the directives that
+	// rely on them may then fail.
+	GCFlags []string
+	// Tests also checks the directives in the _test.go files of the
+	// packages, such as those in benchmark helpers. The test binaries are
+	// built with `go test -c` instead of `go build`.
+	Tests bool
+	// CacheDir, if set, is a directory to cache the compiler output of each
+	// package in. A package whose files and flags haven't changed since a
+	// previous run isn't built again, and its output is read from the cache
+	// instead. Changes to a package's dependencies, which can change the
+	// compiler's decisions, aren't detected, so the cache should be cleared
+	// when they change. The cache isn't used with Tests.
+	CacheDir string
+	// ClearCache removes CacheDir, and everything in it, before the run.
+	ClearCache bool
+	// FailOn, if not empty, lists the directives, such as "bce", whose
+	// failures are errors. Failures of other directives are warnings: they're
+	// still written, but GCAssertWithOptions only returns ErrAssertionsFailed
+	// if there's an error. A directive that can't be parsed is always an
+	// error. Without FailOn, every failure is an error.
+	FailOn []string
+	// AbsPaths makes the paths of failures absolute, for logs that are read
+	// outside of the working directory. It takes precedence over PathRoot.
+	AbsPaths bool
+	// PathRoot, if set, is the directory that the paths of failures are
+	// relative to, instead of the working directory, such as the root of an
+	// editor's workspace. If it's relative, it's relative to the working
+	// directory.
+	PathRoot string
+	// NoSummary leaves out the summary line, with the number of directives
+	// checked and failed, that GCAssertWithOptions writes after the
+	// failures, for tools that parse the failures.
+	NoSummary bool
+	// NilOnFailure makes GCAssertWithOptions return nil when directives
+	// fail, as it did before ErrAssertionsFailed was added, so that callers
+	// have to check whether anything was written. To keep that check
+	// working, it leaves out the summary line, and only writes the path of
+	// the log if something else is written, as with DeleteLog.
+	NilOnFailure bool
+	// DirectivesFile, if set, is the path of a file that lists directives for
+	// code that can't carry //gcassert comments, such as generated code. A
+	// relative path is relative to Cwd. Each line of the file is a path,
+	// relative to the directives file, a colon, a line number, and the
+	// directives for that line as they'd be written after "//gcassert:", as
+	// in "gen/tables.go:42 bce,inline". The directives behave as if they
+	// were in a comment on that line. Blank lines and lines starting with #
+	// are ignored.
+	DirectivesFile string
+	// IgnoreMessages are patterns of compiler messages to ignore, as if the
+	// compiler hadn't printed them, such as known escapes in generated code.
+	// Each pattern is matched against the message without its position.
+	IgnoreMessages []*regexp.Regexp
+	// GOOS and GOARCH, if set, are the operating system and architecture
+	// that the packages are built for, as with the environment variables of
+	// the same name. The compiler's decisions, such as whether a bounds check
+	// is eliminated, can differ between architectures.
+	GOOS, GOARCH string
+	// Tags are build tags, such as "purego", that the packages are loaded
+	// and built with, so that the directives in files with matching build
+	// constraints are checked, and those in files that are excluded by them
+	// aren't.
+	Tags []string
+	// Deps also checks the directives in the dependencies of the packages,
+	// such as a fork of a dependency that's pinned with a replace directive,
+	// but not in the standard library. The dependencies are built with the
+	// same compiler flags as the packages themselves.
+	Deps bool
+	// NoLog doesn't write the compiler's full output to a temporary log
+	// file. By default, GCAssertWithOptions writes the log, and writes its
+	// path to w, before the failures, so that they can be diagnosed.
+	NoLog bool
+	// DeleteLog removes the log after a run in which no directive failed,
+	// and leaves out its path.
+	DeleteLog bool
+
+	// toolchain is the value of GOTOOLCHAIN for a single run.
+	toolchain string
+	// logw is where the path of the log is written. If it's nil, no log is
+	// written, since there's nowhere to report it.
+	logw io.Writer
+}
+
+// LineRange is an inclusive range of lines in a file.
+type LineRange struct {
+	// File is the path of the file, either absolute or relative to the
+	// working directory of the run.
+	File       string
+	Start, End int
+}
+
+// env returns the environment that the go command is run with, or nil to use
+// the current process's environment.
+func (o Options) env() []string {
+	if o.toolchain == "" && o.GOOS == "" && o.GOARCH == "" {
+		return nil
+	}
+	env := os.Environ()
+	if o.toolchain != "" {
+		env = append(env, "GOTOOLCHAIN="+o.toolchain)
+	}
+	if o.GOOS != "" {
+		env = append(env, "GOOS="+o.GOOS)
+	}
+	if o.GOARCH != "" {
+		env = append(env, "GOARCH="+o.GOARCH)
+	}
+	return env
+}
+
+// pathRoot returns the directory that the paths of failures are relative to,
+// or the empty string if they're absolute, for a run in cwd.
+func (o Options) pathRoot(cwd string) string {
+	switch {
+	case o.AbsPaths:
+		return ""
+	case o.PathRoot == "":
+		return cwd
+	case filepath.IsAbs(o.PathRoot):
+		return o.PathRoot
+	}
+	return filepath.Join(cwd, o.PathRoot)
+}
+
+// externalDirectives reads the directives file of o, if it has one.
+func (o Options) externalDirectives() (externalDirectives, error) {
+	if o.DirectivesFile == "" {
+		return nil, nil
+	}
+	path := o.DirectivesFile
+	if !filepath.IsAbs(path) && o.Cwd != "" {
+		path = filepath.Join(o.Cwd, path)
+	}
+	return readDirectivesFile(path)
+}
+
+// target returns the GOOS and GOARCH that the packages are built for, joined
+// by a hyphen, such as "linux-amd64".
+func (o Options) target() string {
+	goos, goarch := o.GOOS, o.GOARCH
+	if goos == "" {
+		goos = os.Getenv("GOOS")
+	}
+	if goos == "" {
+		goos = runtime.GOOS
+	}
+	if goarch == "" {
+		goarch = os.Getenv("GOARCH")
+	}
+	if goarch == "" {
+		goarch = runtime.GOARCH
+	}
+	return goos + "-" + goarch
+}
+
+// buildFlags returns the flags, other than -gcflags, that are passed to both
+// packages.Load and `go build`.
+func (o Options) buildFlags() []string {
+	var flags []string
+	if o.Race {
+		flags = append(flags, "-race")
+	}
+	if len(o.Tags) > 0 {
+		flags = append(flags, "-tags="+strings.Join(o.Tags, ","))
+	}
+	return flags
+}
+
+// GCAssertWithOptions performs the same operation as GCAssertCwd, but allows
+// the build to be configured with opts.
+func GCAssertWithOptions(w io.Writer, opts Options, paths ...string) error {
+	if len(opts.Toolchains) > 0 {
+		var failed error
+		for _, toolchain := range opts.Toolchains {
+			toolchainOpts := opts
+			toolchainOpts.Toolchains = nil
+			toolchainOpts.toolchain = toolchain
+			lw := &labelWriter{w: w, label: toolchain + ": "}
+			err := GCAssertWithOptions(lw, toolchainOpts, paths...)
+			if errors.Is(err, ErrAssertionsFailed) {
+				// Check the rest of the toolchains as well.
+				failed = err
+			} else if err != nil {
+				return fmt.Errorf("%s: %w", toolchain, err)
+			}
+		}
+		return failed
+	}
+
+	if opts.NilOnFailure {
+		opts.NoSummary = true
+		opts.DeleteLog = true
+	}
+	if !opts.NoLog {
+		opts.logw = w
+	}
+	failures, checked, err := run(opts, paths...)
+	if writeErr := writeFailures(w, failures); writeErr != nil {
+		return writeErr
+	}
+	if err != nil {
+		return err
+	}
+	if !opts.NoSummary {
+		if _, err := fmt.Fprintln(w, summary(checked, failures)); err != nil {
+			return err
+		}
+	}
+	return opts.failed(failures)
+}
+
+// summary returns the line that GCAssertWithOptions writes after the
+// failures, with the number of directives that were checked, the number of
+// them that failed, by directive, and the number of malformed directives, such
+// as
+//
+//	gcassert: 12 directives checked, 3 failed (2 inline, 1 bce), 1 malformed
+//
+// A directive that fails more than once, such as a noescape directive on a
+// function with two parameters that escape, is counted once.
+func summary(checked int, failures []Failure) string {
+	directives := "directives"
+	if checked == 1 {
+		directives = "directive"
+	}
+	type directiveKey struct {
+		file            string
+		line, col       int
+		directive, text string
+	}
+	seen := make(map[directiveKey]bool)
+	counts := make(map[string]int)
+	var names []string
+	failed, malformed := 0, 0
+	for _, f := range failures {
+		key := directiveKey{file: f.File, line: f.nodeLine, col: f.nodeCol, directive: f.Directive}
+		if f.Directive == "" {
+			// A comment can have more than one malformed directive.
+			key.text = f.Message
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if f.Directive == "" {
+			malformed++
+			continue
+		}
+		failed++
+		if counts[f.Directive] == 0 {
+			names = append(names, f.Directive)
+		}
+		counts[f.Directive]++
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	line := fmt.Sprintf("gcassert: %d %s checked, %d failed", checked, directives, failed)
+	if failed > 0 {
+		parts := make([]string, len(names))
+		for i, name := range names {
+			parts[i] = fmt.Sprintf("%d %s", counts[name], name)
+		}
+		line += " (" + strings.Join(parts, ", ") + ")"
+	}
+	if malformed > 0 {
+		line += fmt.Sprintf(", %d malformed", malformed)
+	}
+	return line
+}
+
+// ErrAssertionsFailed is returned by GCAssert and the functions like it when a
+// directive fails. The failures themselves are written to the io.Writer.
+var ErrAssertionsFailed = errors.New("assertions failed")
+
+// BuildError is returned by GCAssert and the functions like it when the
+// packages don't compile, either when they're loaded or when they're built
+// for the compiler's decisions.
+type BuildError struct {
+	Err error
+}
+
+func (e *BuildError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
+
+// Exit codes for the errors returned by GCAssert and the functions like it,
+// as returned by ExitCode.
+const (
+	ExitOK               = 0
+	ExitAssertionsFailed = 1
+	ExitBuildFailed      = 2
+	ExitConfigError      = 3
+)
+
+// ExitCode maps err, an error returned by GCAssert or one of the functions
+// like it, to an exit code, for scripts that need to tell the outcomes apart:
+// ExitOK if err is nil, ExitAssertionsFailed if it's ErrAssertionsFailed,
+// ExitBuildFailed if it's a BuildError, and ExitConfigError for any other
+// error, such as a missing package or directives file.
+func ExitCode(err error) int {
+	var buildErr *BuildError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, ErrAssertionsFailed):
+		return ExitAssertionsFailed
+	case errors.As(err, &buildErr):
+		return ExitBuildFailed
+	}
+	return ExitConfigError
+}
+
+// failed returns ErrAssertionsFailed if any of failures is an error, rather
+// than a warning, under o.
+func (o Options) failed(failures []Failure) error {
+	if o.NilOnFailure {
+		return nil
+	}
+	for _, f := range failures {
+		if !o.warning(f) {
+			return ErrAssertionsFailed
+		}
+	}
+	return nil
+}
+
+// warning returns whether f is a warning under o, rather than an error.
+func (o Options) warning(f Failure) bool {
+	return len(o.FailOn) > 0 && f.Directive != "" && !slices.Contains(o.FailOn, f.Directive)
+}
+
+// GCAssertSource checks the //gcassert directives in src, the source of a
+// single Go file, and returns the failures. This is a convenience for testing
+// the optimization characteristics of generated code: src is written to a
+// package in a temporary module, which is removed afterward. The Cwd and
+// Toolchains fields of opts are ignored.
+func GCAssertSource(src string, opts Options) ([]Failure, error) {
+	dir, err := os.MkdirTemp("", "gcassert-source-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.WriteFile(filepath.Join(dir, "source.go"), []byte(src), 0o644); err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("go", "mod", "init", "gcassertsource")
+	cmd.Dir = dir
+	cmd.Env = opts.env()
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("go mod init: %w: %s", err, out)
+	}
+	opts.Cwd = dir
+	opts.Toolchains = nil
+	failures, _, err := run(opts, ".")
+	return failures, err
+}
+
+// load resolves the working directory of opts and loads the packages at
+// paths with their syntax and type information. It's an error if there are
+// no paths, if they match no packages, or if any package fails to load, since
+// the directives of a package that can't be loaded would silently go
+// unchecked.
+func load(opts Options, fileSet *token.FileSet, paths ...string) (string, []*packages.Package, error) {
+	if len(paths) == 0 {
+		return "", nil, errors.New("no packages specified")
+	}
+	cwd := opts.Cwd
+	if cwd == "" {
+		var err error
+		cwd, err = os.Getwd()
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	mode := packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedCompiledGoFiles |
+		packages.NeedTypesInfo | packages.NeedTypes | packages.NeedTypesSizes
+	if opts.Deps {
+		// The dependencies are loaded with their syntax and type
+		// information as well, so that their directives can be checked.
+		mode |= packages.NeedImports | packages.NeedDeps | packages.NeedModule
+	}
+	pkgs, err := packages.Load(&packages.Config{
+		Dir:        cwd,
+		Mode:       mode,
+		Fset:       fileSet,
+		BuildFlags: opts.buildFlags(),
+		Env:        opts.env(),
+		Tests:      opts.Tests,
+	}, paths...)
+	if err != nil {
+		return cwd, nil, err
+	}
+	if len(pkgs) == 0 {
+		return cwd, nil, fmt.Errorf("no packages found for %s", strings.Join(paths, " "))
+	}
+	var errs []error
+	// compileErrs is set if any of the errors are in the packages' code,
+	// rather than in finding them.
+	compileErrs := false
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		for _, err := range pkg.Errors {
+			errs = append(errs, err)
+			if err.Kind != packages.ListError {
+				compileErrs = true
+			}
+		}
+	})
+	if len(errs) > 0 {
+		err := fmt.Errorf("packages failed to load, so their directives weren't checked: %w", errors.Join(errs...))
+		if compileErrs {
+			return cwd, nil, &BuildError{Err: err}
+		}
+		return cwd, nil, err
+	}
+	if opts.Deps {
+		pkgs = withDeps(pkgs)
+	}
+	return cwd, dedupePackages(pkgs), nil
+}
+
+// buildPath returns the import path that names pkg on the go command line.
+// The packages that are loaded with Tests, such as the test variant
+// "p [p.test]", the external test package "p_test [p.test]" and the
+// generated test main "p.test", are all built by naming p.
+func buildPath(pkg *packages.Package) string {
+	if i := strings.Index(pkg.ID, " ["); i >= 0 {
+		return strings.TrimSuffix(strings.TrimSuffix(pkg.ID[i+2:], "]"), ".test")
+	}
+	if pkg.Name == "main" && strings.HasSuffix(pkg.ID, ".test") {
+		return strings.TrimSuffix(pkg.ID, ".test")
+	}
+	return pkg.PkgPath
+}
+
+// withDeps returns pkgs and their dependencies, other than those in the
+// standard library, which are the packages that don't belong to a module.
+func withDeps(pkgs []*packages.Package) []*packages.Package {
+	var all []*packages.Package
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		if pkg.Module != nil || slices.Contains(pkgs, pkg) {
+			all = append(all, pkg)
+		}
+	})
+	return all
+}
+
+// dedupePackages removes the packages whose files are all in another package,
+// so that each file is only analyzed once. When tests are loaded, a package
+// with _test.go files is loaded both on its own and as the test variant
+// compiled into the test binary. The test variant is kept, because it's the
+// one that's built and it includes the _test.go files.
+func dedupePackages(pkgs []*packages.Package) []*packages.Package {
+	sorted := make([]*packages.Package, len(pkgs))
+	copy(sorted, pkgs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].CompiledGoFiles) > len(sorted[j].CompiledGoFiles)
+	})
+	seen := make(map[string]bool)
+	keep := make(map[*packages.Package]bool)
+	for _, pkg := range sorted {
+		for _, file := range pkg.CompiledGoFiles {
+			if !seen[file] {
+				seen[file] = true
+				keep[pkg] = true
+			}
+		}
+	}
+	var deduped []*packages.Package
+	for _, pkg := range pkgs {
+		if keep[pkg] {
+			deduped = append(deduped, pkg)
+		}
+	}
+	return deduped
+}
+
+// PackageDirs returns the directories of the packages at paths, loaded as
+// opts configures them. Unlike GCAssertWithOptions, it doesn't fail if the
+// packages don't compile, so that they can be watched until they're fixed.
+func PackageDirs(opts Options, paths ...string) ([]string, error) {
+	pkgs, err := packages.Load(&packages.Config{
+		Dir:        opts.Cwd,
+		Mode:       packages.NeedName | packages.NeedFiles,
+		BuildFlags: opts.buildFlags(),
+		Env:        opts.env(),
+		Tests:      opts.Tests,
+	}, paths...)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, pkg := range pkgs {
+		for _, file := range append(pkg.GoFiles, pkg.IgnoredFiles...) {
+			if dir := filepath.Dir(file); !seen[dir] {
+				seen[dir] = true
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("no packages found for %s", strings.Join(paths, " "))
+	}
+	return dirs, nil
+}
+
+// GCAssertCoverage writes a coverage profile of the packages at paths to w, in
+// the format written by `go test -coverprofile`. A statement is counted as
+// covered if it's within a node annotated with a //gcassert directive, or if it
+// calls a function declared with one, so the profile can be viewed with
+// `go tool cover -html` to audit which code has its optimizations asserted.
+// The packages aren't built, and malformed directives are ignored.
+func GCAssertCoverage(w io.Writer, opts Options, paths ...string) error {
+	fileSet := token.NewFileSet()
+	cwd, pkgs, err := load(opts, fileSet, paths...)
+	if err != nil {
+		return err
+	}
+	external, err := opts.externalDirectives()
+	if err != nil {
+		return err
+	}
+	directiveMap, inlineFuncs, err := parseDirectives(pkgs, fileSet, &reporter{cwd: cwd, root: cwd, fileSet: fileSet}, external)
+	if err != nil {
+		return err
+	}
+	// annotated is the set of functions that are declared with a directive.
+	annotated := make(map[types.Object]bool)
+	for obj := range inlineFuncs {
+		annotated[obj] = true
+	}
+	for _, pkg := range pkgs {
+		for i := range pkg.Syntax {
+			for _, info := range directiveMap[pkg.CompiledGoFiles[i]] {
+				if fd, ok := info.n.(*ast.FuncDecl); ok && pkg.TypesInfo.Defs[fd.Name] != nil {
+					annotated[pkg.TypesInfo.Defs[fd.Name]] = true
+				}
+			}
+		}
+	}
+
+	if _, err := fmt.Fprintln(w, "mode: set"); err != nil {
+		return err
+	}
+	for _, pkg := range pkgs {
+		for i, file := range pkg.Syntax {
+			// covered marks the lines that are spanned by an annotated node.
+			covered := make(map[int]bool)
+			for _, info := range directiveMap[pkg.CompiledGoFiles[i]] {
+				start := fileSet.Position(info.n.Pos()).Line
+				end := fileSet.Position(info.n.End()).Line
+				for l := start; l <= end; l++ {
+					covered[l] = true
+				}
+			}
+			name := pkg.PkgPath + "/" + filepath.Base(pkg.CompiledGoFiles[i])
+			for _, n := range coverageBlocks(file) {
+				start := fileSet.Position(n.Pos())
+				end := fileSet.Position(n.End())
+				count := 0
+				if covered[start.Line] || callsAnnotated(pkg.TypesInfo, n, annotated) {
+					count = 1
+				}
+				if _, err := fmt.Fprintf(w, "%s:%d.%d,%d.%d 1 %d\n",
+					name, start.Line, start.Column, end.Line, end.Column, count); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// GCAssertList writes the directives that gcassert finds in the packages at
+// paths to w, without building them. For each file, it writes each line that
+// has directives, and the callsites on the line that are checked for inlining,
+// with their columns, to show where each directive was attached. Malformed
+// directives are written after the list, in the same format as failures.
+func GCAssertList(w io.Writer, paths ...string) error {
+	return GCAssertListWithOptions(w, Options{}, paths...)
+}
+
+// GCAssertListWithOptions performs the same operation as GCAssertList, but
+// allows the packages to be loaded with opts.
+func GCAssertListWithOptions(w io.Writer, opts Options, paths ...string) error {
+	fileSet := token.NewFileSet()
+	cwd, pkgs, err := load(opts, fileSet, paths...)
+	if err != nil {
+		return err
+	}
+	external, err := opts.externalDirectives()
+	if err != nil {
+		return err
+	}
+	r := &reporter{cwd: cwd, root: opts.pathRoot(cwd), fileSet: fileSet}
+	directiveMap, _, err := parseDirectives(pkgs, fileSet, r, external)
+	if err != nil {
+		return err
+	}
+
+	files := make([]string, 0, len(directiveMap))
+	for file := range directiveMap {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		name := file
+		if r.root != "" {
+			if rel, err := filepath.Rel(r.root, file); err == nil {
+				name = rel
+			}
+		}
+		if _, err := fmt.Fprintln(w, name); err != nil {
+			return err
+		}
+		lines := make([]int, 0, len(directiveMap[file]))
+		for line := range directiveMap[file] {
+			lines = append(lines, line)
+		}
+		sort.Ints(lines)
+		for _, line := range lines {
+			if _, err := fmt.Fprintf(w, "\t%d: %s\n", line, listLine(directiveMap[file][line])); err != nil {
+				return err
+			}
+		}
+	}
+	return writeFailures(w, r.failures)
+}
+
+// listLine returns the description of a line that GCAssertList writes: its
+// directives, with their arguments, and its inlinable callsites, such as
+//
+//	bce, inline call to lookup at column 18
+func listLine(info lineInfo) string {
+	var parts []string
+	for i, d := range info.directives {
+		arg, ok := info.args[i]
+		switch {
+		case !ok:
+			parts = append(parts, d.String())
+		case d == cost:
+			parts = append(parts, d.String()+"<="+arg)
+		case d == allocs || d == maxtextsize:
+			parts = append(parts, d.String()+":"+arg)
+		default:
+			parts = append(parts, d.String()+"="+arg)
+		}
+	}
+	for _, cs := range info.inlinableCallsites {
+		d := inline
+		switch {
+		case cs.noinline:
+			d = noinline
+		case cs.callfree:
+			d = callfree
+		case cs.deep != nil:
+			d = inlinedeep
+		}
+		if cs.callee == "" {
+			parts = append(parts, fmt.Sprintf("%s call at column %d", d, cs.colNo))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s call to %s at column %d", d, cs.callee, cs.colNo))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// callsAnnotated returns whether node calls a function in annotated, directly
+// or through an instantiation of it.
+func callsAnnotated(info *types.Info, node ast.Node, annotated map[types.Object]bool) bool {
+	found := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return !found
+		}
+		fun := ast.Unparen(call.Fun)
+		switch n := fun.(type) {
+		case *ast.IndexExpr:
+			fun = n.X
+		case *ast.IndexListExpr:
+			fun = n.X
+		}
+		var ident *ast.Ident
+		switch fun := fun.(type) {
+		case *ast.Ident:
+			ident = fun
+		case *ast.SelectorExpr:
+			ident = fun.Sel
+		}
+		if fn, ok := info.Uses[ident].(*types.Func); ok && annotated[fn.Origin()] {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// coverageBlocks returns the nodes of file that are reported as blocks in a
+// coverage profile, in source order: package level variable specs, and the
+// statements in function bodies that don't contain other statements.
+func coverageBlocks(file *ast.File) []ast.Node {
+	var blocks []ast.Node
+	for _, decl := range file.Decls {
+		if d, ok := decl.(*ast.GenDecl); ok && d.Tok == token.VAR {
+			for _, spec := range d.Specs {
+				blocks = append(blocks, spec)
+			}
+		}
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		var list []ast.Stmt
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+			list = n.List
+		case *ast.CaseClause:
+			list = n.Body
+		case *ast.CommClause:
+			list = n.Body
+		}
+		for _, stmt := range list {
+			for {
+				labeled, ok := stmt.(*ast.LabeledStmt)
+				if !ok {
+					break
+				}
+				stmt = labeled.Stmt
+			}
+			switch stmt.(type) {
+			case *ast.BlockStmt, *ast.IfStmt, *ast.ForStmt, *ast.RangeStmt, *ast.SwitchStmt,
+				*ast.TypeSwitchStmt, *ast.SelectStmt:
+			default:
+				blocks = append(blocks, stmt)
+			}
+		}
+		return true
+	})
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].Pos() < blocks[j].Pos() })
+	return blocks
+}
+
+// run loads and builds the packages at paths and returns the failures to
+// comply with //gcassert directives.
+func run(opts Options, paths ...string) ([]Failure, int, error) {
+	fileSet := token.NewFileSet()
+	cwd, pkgs, err := load(opts, fileSet, paths...)
+	if err != nil {
+		return nil, 0, err
+	}
+	failures, checked, err := check(opts, cwd, fileSet, pkgs, paths...)
+	for i := range failures {
+		failures[i].Warning = opts.warning(failures[i])
+	}
+	return failures, checked, err
+}
+
+// check builds pkgs, the packages at paths, which were loaded in cwd, and
+// returns the failures to comply with their //gcassert directives, and the
+// number of directives that were checked.
+func check(opts Options, cwd string, fileSet *token.FileSet, pkgs []*packages.Package, paths ...string) ([]Failure, int, error) {
+	r := &reporter{cwd: cwd, root: opts.pathRoot(cwd), fileSet: fileSet, rewrite: opts.RewriteMessage, lines: opts.Lines}
+	external, err := opts.externalDirectives()
+	if err != nil {
+		return nil, 0, err
+	}
+	directiveMap, uncalled, err := parseDirectives(pkgs, fileSet, r, external)
+	if err != nil {
+		return r.failures, 0, err
+	}
+	checked := directiveMap.count()
+	if opts.StrictDirectives {
+		checkMalformedDirectives(pkgs, r)
+		checkUncalledInlineFuncs(pkgs, uncalled, r)
+	}
+
+	// Next: invoke Go compiler with -m flags to get the compiler to print
+	// its optimization decisions.
+
+	// goarch is the target architecture, which is only needed for
+	// directives whose assembly patterns are architecture specific.
+	var goarch string
+	if directiveMap.has(nospill) || directiveMap.has(noretspill) || directiveMap.has(register) {
+		cmd := exec.Command("go", "env", "GOARCH")
+		cmd.Dir = cwd
+		cmd.Env = opts.env()
+		out, err := cmd.Output()
+		if err != nil {
+			return r.failures, checked, fmt.Errorf("go env GOARCH: %w", err)
+		}
+		goarch = strings.TrimSpace(string(out))
+	}
+
+	gcflags := "-m=2 -d=ssa/check_bce/debug=1"
+	if directiveMap.needsAsm() {
+		gcflags += " -S"
+	}
+	if directiveMap.has(opendefer) {
+		// Report how each defer statement is compiled.
+		gcflags += " -d=defer"
+	}
+	if directiveMap.has(nilcheck) {
+		// Report each nil check that's removed, and each that's generated.
+		gcflags += " -d=nil"
+	}
+	for _, pass := range directiveMap.args(ssa) {
+		// Report the optimizations that each pass makes.
+		gcflags += " -d=ssa/" + pass + "/debug=1"
+	}
+	for _, flag := range opts.GCFlags {
+		gcflags += " " + flag
+	}
+	args := []string{"build", "-gcflags=" + gcflags}
+	if opts.Tests {
+		// Build the test binaries, which include the _test.go files, and
+		// discard them.
+		dir, err := os.MkdirTemp("", "gcassert-test-*")
+		if err != nil {
+			return r.failures, checked, err
+		}
+		defer os.RemoveAll(dir)
+		args = []string{"test", "-c", "-o", dir, "-gcflags=" + gcflags}
+	}
+	args = append(args, opts.buildFlags()...)
+
+	// replay is the cached output of the packages that don't need to be
+	// built again, and built is the import paths of the packages that do.
+	var cache *outputCache
+	var replay, built []string
+	if opts.CacheDir != "" && !opts.Tests {
+		if opts.ClearCache {
+			if err := os.RemoveAll(opts.CacheDir); err != nil {
+				return r.failures, checked, err
+			}
+		}
+		// The output depends on the flags, toolchain and target it's built
+		// with, and contains paths relative to the working directory.
+		cache, err = newOutputCache(opts.CacheDir, pkgs, append([]string{cwd, opts.toolchain, opts.target()}, args...))
+		if err != nil {
+			return r.failures, checked, err
+		}
+		for _, pkg := range pkgs {
+			if lines, ok := cache.get(pkg.PkgPath); ok {
+				replay = append(replay, lines...)
+			} else {
+				built = append(built, pkg.PkgPath)
+			}
+		}
+		args = append(args, built...)
+	} else if opts.Deps {
+		// The compiler flags only apply to the packages that are named
+		// on the command line, so the dependencies have to be named too.
+		for _, pkg := range pkgs {
+			if path := buildPath(pkg); !slices.Contains(args, path) {
+				args = append(args, path)
+			}
+		}
+	} else {
+		for i := range paths {
+			if filepath.IsAbs(paths[i]) {
+				args = append(args, paths[i])
+			} else {
+				args = append(args, "./"+paths[i])
+			}
+		}
+	}
+	cmd := exec.Command("go", args...)
+	cmd.Dir = cwd
+	cmd.Env = opts.env()
+	pr, pw := io.Pipe()
+	writers := []io.Writer{pw}
+	// Create a temp file to log all diagnostic output.
+	var f *os.File
+	if opts.logw != nil {
+		f, err = os.CreateTemp("", "gcassert-"+opts.target()+"-*.log")
+		if err != nil {
+			return r.failures, checked, err
+		}
+		if !opts.DeleteLog {
+			if _, err := fmt.Fprintf(opts.logw, "See %s for full output.\n", f.Name()); err != nil {
+				return r.failures, checked, err
+			}
+		}
+		// Log full 'go build' command.
+		fmt.Fprintln(f, cmd)
+		writers = append(writers, f)
+	}
+	// out is the output of the build, which is cached.
+	var out strings.Builder
+	if cache != nil {
+		writers = append(writers, &out)
+	}
+	mw := io.MultiWriter(writers...)
+	cmd.Stdout = mw
+	cmd.Stderr = mw
+	cmdErr := make(chan error, 1)
+
+	go func() {
+		for _, line := range replay {
+			fmt.Fprintln(pw, line)
+		}
+		var err error
+		if cache == nil || len(built) > 0 {
+			err = cmd.Run()
+		}
+		if err == nil && cache != nil {
+			err = cache.put(built, out.String())
+		}
+		if f != nil {
+			_ = f.Close()
+		}
+		cmdErr <- err
+		_ = pw.Close()
+	}()
+
+	scanner := bufio.NewScanner(pr)
+	boundsCheck := "Found IsInBounds"
+	sliceBoundsCheck := "Found IsSliceInBounds"
+
+	resolver := newPathResolver(cwd, pkgs)
+	asm := newAsmListing(resolver)
+
+	// escapeHeader is the most recent "escapes to heap:" message. It's
+	// followed by indented messages that explain the flows that caused the
+	// escape, including the position of each step of the flow.
+	escapeHeader := ""
+	escapeFlowAt := regexp.MustCompile(` at (.+):(\d+):\d+$`)
+	// allocMessages maps filepath to line number to the compiler's messages
+	// about heap allocations made by that line.
+	allocMessages := make(map[string]map[int][]string)
+	// boundsChecks maps filepath to line number to the number of bounds
+	// checks on that line.
+	boundsChecks := make(map[string]map[int]int)
+	// escapeReported records the escapes that have already failed a
+	// noescapecall directive, keyed by the directive's file and line and the
+	// position of the escape.
+	escapeReported := make(map[string]bool)
+	// inlineMessages maps filepath to line number to the compiler's message
+	// about whether the function declared on that line can be inlined, which
+	// includes its inline cost.
+	inlineMessages := make(map[string]map[int]string)
+	// escapeMessages maps filepath to line number to the escape analysis
+	// messages for that line, and escapeTraces are the failed noescape
+	// directives that they're added to, when opts.EscapeTrace is set.
+	escapeMessages := make(map[string]map[int][]string)
+	var escapeTraces []escapeTrace
+	// reportedLines maps filepath to the lines that the compiler printed any
+	// message about, when opts.StrictDirectives is set.
+	reportedLines := make(map[string]map[int]bool)
+	paramFuncs := noescapeFuncs(directiveMap, fileSet)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if ok, err := asm.scan(line); err != nil {
+			return r.failures, checked, err
+		} else if ok {
+			continue
+		}
+		matches := optInfo.FindStringSubmatch(line)
+		if len(matches) != 0 {
+			path := matches[1]
+			lineNo, err := strconv.Atoi(matches[2])
+			if err != nil {
+				return r.failures, checked, err
+			}
+			colNo, err := strconv.Atoi(matches[3])
+			if err != nil {
+				return r.failures, checked, err
+			}
+			message := matches[4]
+			if ignored(opts.IgnoreMessages, message) {
+				if !strings.HasPrefix(message, " ") {
+					// Don't attribute the flows that explain an ignored
+					// escape to the previous one.
+					escapeHeader = ""
+				}
+				continue
+			}
+
+			path = resolver.resolve(path)
+			if opts.StrictDirectives {
+				if reportedLines[path] == nil {
+					reportedLines[path] = make(map[int]bool)
+				}
+				reportedLines[path][lineNo] = true
+			}
+			if message == boundsCheck || message == sliceBoundsCheck {
+				if boundsChecks[path] == nil {
+					boundsChecks[path] = make(map[int]int)
+				}
+				boundsChecks[path][lineNo]++
+			}
+			if strings.HasPrefix(message, "can inline ") || strings.HasPrefix(message, "cannot inline ") {
+				if inlineMessages[path] == nil {
+					inlineMessages[path] = make(map[int]string)
+				}
+				// A func literal on the same line is reported after the
+				// function that contains it.
+				if _, ok := inlineMessages[path][lineNo]; !ok {
+					inlineMessages[path][lineNo] = message
+				}
+			}
+			if strings.HasPrefix(message, "moved to heap:") || strings.HasSuffix(message, "escapes to heap") {
+				if allocMessages[path] == nil {
+					allocMessages[path] = make(map[int][]string)
+				}
+				allocMessages[path][lineNo] = append(allocMessages[path][lineNo], message)
+			}
+			if opts.EscapeTrace && (escapeMessage.MatchString(message) ||
+				escapeHeader != "" && strings.HasPrefix(message, " ")) {
+				if escapeMessages[path] == nil {
+					escapeMessages[path] = make(map[int][]string)
+				}
+				escapeMessages[path][lineNo] = append(escapeMessages[path][lineNo],
+					fmt.Sprintf("%d:%d: %s", lineNo, colNo, message))
+			}
+			if !strings.HasPrefix(message, " ") {
+				escapeHeader = ""
+				if strings.HasSuffix(message, "escapes to heap:") {
+					escapeHeader = message
+				}
+			} else if at := escapeFlowAt.FindStringSubmatch(message); escapeHeader != "" && len(at) != 0 {
+				// A noescapecall directive fails if any step of an escaping
+				// flow happens on its line.
+				atPath := resolver.resolve(at[1])
+				atLine, err := strconv.Atoi(at[2])
+				if err != nil {
+					return r.failures, checked, err
+				}
+				info := directiveMap[atPath][atLine]
+				key := fmt.Sprintf("%s:%d %s:%d:%d", atPath, atLine, path, lineNo, colNo)
+				for _, d := range info.directives {
+					if d == noescapecall && !escapeReported[key] {
+						escapeReported[key] = true
+						r.fail(info.n, d, escapeHeader)
+					}
+				}
+			}
+			if escapesVar(message) {
+				for _, fn := range paramFuncs[path] {
+					// The noescape directive checks the line of the
+					// function itself, like any other. The compiler reports
+					// a parameter at the position of its declaration.
+					if fn.start < lineNo && lineNo <= fn.end && fn.params[[2]int{lineNo, colNo}] {
+						r.failAt(fn.decl, noescape, escapeFailure(pkgs, fileSet, path, lineNo, colNo, message), lineNo, colNo)
+					}
+				}
+			}
+			if lineToDirectives := directiveMap[path]; lineToDirectives != nil {
+				info := lineToDirectives[lineNo]
+				if len(info.directives) > 0 {
+					if info.passedDirective == nil {
+						info.passedDirective = make(map[int]bool)
+						lineToDirectives[lineNo] = info
+					}
+				}
+				for i, d := range info.directives {
+					switch d {
+					case bce:
+						if message == boundsCheck || message == sliceBoundsCheck {
+							// Error! We found a bounds check where the user expected
+							// there to be none.
+							// Print out the user's code lineNo that failed the assertion,
+							// the assertion itself, and the compiler output that
+							// proved that the assertion failed.
+							r.failAt(info.n, d, message, lineNo, colNo)
+						}
+					case inline, noinline:
+						// A directive that names a callee only counts the
+						// calls to that function.
+						if callee, ok := strings.CutPrefix(message, "inlining call to "); ok &&
+							(info.args[i] == "" || inlinedFuncName(callee) == info.args[i]) {
+							info.passedDirective[i] = true
+						}
+					case opendefer:
+						if message == "open-coded defer" {
+							info.passedDirective[i] = true
+						}
+					case devirt:
+						if strings.HasPrefix(message, "devirtualizing ") {
+							info.passedDirective[i] = true
+						}
+					case stack:
+						// The entry records that the compiler reported on
+						// an allocation on the line, whether or not it's on
+						// the stack. An allocation that escapes is reported
+						// with a summary after the flows that explain it.
+						if strings.HasSuffix(message, " does not escape") {
+							info.passedDirective[i] = true
+						} else if strings.HasSuffix(message, " escapes to heap") || strings.HasPrefix(message, "moved to heap:") {
+							info.passedDirective[i] = true
+							r.failAt(info.n, d, message, lineNo, colNo)
+						}
+					case ssa:
+						for _, prefix := range ssaPasses[info.args[i]] {
+							if strings.HasPrefix(message, prefix) {
+								info.passedDirective[i] = true
+							}
+						}
+					case nilcheck:
+						// The entry records that the compiler reported on
+						// the line's nil checks, whether or not it removed
+						// them.
+						switch message {
+						case "removed nil check":
+							info.passedDirective[i] = true
+						case "generated nil check":
+							info.passedDirective[i] = true
+							r.failAt(info.n, d, message, lineNo, colNo)
+						}
+					case noescape:
+						if strings.HasSuffix(message, "escapes to heap:") || strings.Contains(message, "leaking param:") {
+							reported := len(r.failures)
+							failure := escapeFailure(pkgs, fileSet, path, lineNo, colNo, message)
+							r.failAt(info.n, d, failure, lineNo, colNo)
+							if opts.EscapeTrace && len(r.failures) > reported {
+								escapeTraces = append(escapeTraces, escapeTrace{failure: reported, path: path, n: info.n})
+							}
+						}
+					case noescapeclosure:
+						// The compiler decides whether a func literal escapes
+						// using the leak analysis of the function it is passed
+						// to.
+						if message == "func literal escapes to heap:" {
+							r.fail(info.n, d, message)
+						}
+					}
+				}
+				if strings.HasPrefix(message, "devirtualizing ") && (info.interfaceCall || len(info.inlinableCallsites) > 0) {
+					info.devirtualized = true
+					lineToDirectives[lineNo] = info
+				}
+				for i := range info.inlinableCallsites {
+					// Other messages, such as those about devirtualizing a
+					// call or about nil checks, can be reported at the column
+					// of a callsite.
+					cs := &info.inlinableCallsites[i]
+					if callee, ok := strings.CutPrefix(message, "inlining call to "); ok && cs.colNo == colNo {
+						// The compiler reports the calls that it inlines
+						// into an inlined function at the outermost
+						// callsite, so only a message that names the
+						// callee passes it.
+						name := inlinedFuncName(callee)
+						if cs.callee == "" || name == cs.callee {
+							cs.passed = true
+						}
+						cs.inlined = append(cs.inlined, name)
+					}
+				}
+			}
+		}
+	}
+
+	for _, t := range escapeTraces {
+		// Trace the whole function that contains the directive, or just the
+		// directive's node if it isn't in a function.
+		start, end := fileSet.Position(t.n.Pos()).Line, fileSet.Position(t.n.End()).Line
+		if fd := enclosingFunc(pkgs, t.path, t.n); fd != nil {
+			start, end = fileSet.Position(fd.Pos()).Line, fileSet.Position(fd.End()).Line
+		}
+		f := &r.failures[t.failure]
+		for l := start; l <= end; l++ {
+			for _, message := range escapeMessages[t.path][l] {
+				f.Trace = append(f.Trace, f.File+":"+message)
+			}
+		}
+	}
+
+	// allocations returns the heap allocations made on lines start to end of
+	// file k. Escape analysis doesn't report channels, which are always
+	// allocated by the runtime, so those are found in the assembly listing.
+	allocations := func(k string, start, end int) []string {
+		var found []string
+		for l := start; l <= end; l++ {
+			found = append(found, allocMessages[k][l]...)
+			for range asm.matching(k, l, l, chanAlloc) {
+				found = append(found, "channel allocated by runtime.makechan")
+			}
+		}
+		return found
+	}
+	// growths returns the appends and map assignments on lines start to end
+	// of file k that may have to grow their slice or map. The compiler
+	// leaves a call to runtime.growslice in an append unless it proves that
+	// the capacity suffices, and every map assignment calls
+	// runtime.mapassign, which grows the map when it's full.
+	growths := func(k string, start, end int) []string {
+		var found []string
+		for range asm.matching(k, start, end, growInstr) {
+			found = append(found, "append may grow the slice")
+		}
+		for range asm.matching(k, start, end, mapAssignInstr) {
+			found = append(found, "map assignment may grow the map")
+		}
+		return found
+	}
+
+	// varSpills maps the variable named by each register directive to the
+	// instruction that spills it. The compiler names the stack slot that it
+	// spills a local variable to after the variable.
+	varSpills := make(map[string]*regexp.Regexp)
+	for _, name := range directiveMap.args(register) {
+		varSpills[name] = regexp.MustCompile(`^MOV\w* [A-Z]\w*, \S*\.` + regexp.QuoteMeta(name) + `([+-]\d+)?\(SP\)$`)
+	}
+
+	keys := make([]string, 0, len(directiveMap))
+	for k := range directiveMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var lines []int
+	for _, k := range keys {
+		lines = lines[:0]
+		lineToDirectives := directiveMap[k]
+		for line := range lineToDirectives {
+			lines = append(lines, line)
+		}
+		sort.Ints(lines)
+		for _, line := range lines {
+			info := lineToDirectives[line]
+			for _, d := range info.inlinableCallsites {
+				// An inlining directive passes if it has compiler output. For
+				// each inlining directive, check if there was matching compiler
+				// output and fail if not. A noinline directive is the reverse.
+				if d.noinline {
+					if d.passed {
+						r.failAt(d.call, noinline, noinlineFailure, line, d.colNo)
+					}
+				} else if d.callfree {
+					if !d.passed {
+						r.failAt(d.call, callfree, "call in loop was not inlined", line, d.colNo)
+					}
+				} else if !d.passed {
+					r.failAt(d.call, inline, notInlinedFailure(info, d.viaInterface, d.stmt), line, d.colNo)
+				} else {
+					for _, name := range d.nested {
+						if !slices.Contains(d.inlined, name) {
+							r.failAt(d.call, inlinedeep, fmt.Sprintf("call to %s in %s was not inlined", name, d.deep.Name()), line, d.colNo)
+						}
+					}
+				}
+			}
+			for i, d := range info.directives {
+				switch d {
+				case bce, noescape:
+					// These directives pass silently when the compiler
+					// reports nothing, which is also what happens when one
+					// is attached to the wrong line, such as after an edit.
+					if opts.StrictDirectives && !reportedLines[k][line] && !analyzable(pkgs, k, info.n, d) {
+						r.fail(info.n, d, "directive matched no analyzable expression")
+					}
+				case inline:
+					if !info.passedDirective[i] {
+						if callee := info.args[i]; callee != "" {
+							r.fail(info.n, d, fmt.Sprintf("call to %s was not inlined", callee))
+						} else {
+							r.fail(info.n, d, notInlinedFailure(info, info.interfaceCall, ""))
+						}
+					}
+				case noinline:
+					if info.passedDirective[i] {
+						r.fail(info.n, d, noinlineFailure)
+					}
+				case opendefer:
+					// A defer is open-coded, run inline at each return
+					// rather than from a defer record, unless it's in a
+					// loop or its function has too many defers or returns.
+					if !info.passedDirective[i] {
+						r.fail(info.n, d, "defer was not open-coded")
+					}
+				case devirt:
+					// An interface method call is devirtualized when the
+					// compiler can prove the concrete type of the
+					// interface value, usually because it was converted
+					// from that type in the same function.
+					if !info.passedDirective[i] {
+						r.fail(info.n, d, "interface call was not devirtualized")
+					}
+				case nilcheck:
+					if !info.passedDirective[i] {
+						r.fail(info.n, d, "no nil check was removed")
+					}
+				case stack:
+					if !info.passedDirective[i] {
+						r.fail(info.n, d, "no allocation found to prove is on the stack")
+					}
+				case ssa:
+					if !info.passedDirective[i] {
+						r.fail(info.n, d, fmt.Sprintf("ssa pass %s reported no optimization", info.args[i]))
+					}
+				case staticinit:
+					// A staticinit directive passes if none of the lines
+					// of the annotated declaration have code in the
+					// package's init function.
+					end := fileSet.Position(info.n.End()).Line
+					for l := line; l <= end; l++ {
+						if asm.initLines[k][l] {
+							r.fail(info.n, d, "global requires runtime initialization")
+							break
+						}
+					}
+				case allocs:
+					// An allocs directive counts the heap allocations on
+					// all of the lines of the annotated node.
+					want, _ := strconv.Atoi(info.args[i])
+					found := allocations(k, line, fileSet.Position(info.n.End()).Line)
+					if len(found) != want {
+						r.fail(info.n, d,
+							fmt.Sprintf("expected %d allocations, found %d: %s", want, len(found), strings.Join(found, "; ")))
+					}
+				case noalloc:
+					// A noalloc directive fails on any heap allocation that
+					// escape analysis reports on the lines of the annotated
+					// node, such as the body of a loop or a function.
+					found := allocations(k, line, fileSet.Position(info.n.End()).Line)
+					for _, other := range info.directives {
+						if other == noescape {
+							// Don't report the escapes on the directive's
+							// line a second time. allocations lists them
+							// first.
+							found = found[len(allocMessages[k][line]):]
+							break
+						}
+					}
+					if len(found) > 0 {
+						r.fail(info.n, d, "unexpected allocation: "+strings.Join(found, "; "))
+					}
+				case nogrow:
+					// A nogrow directive fails if an append on the lines of
+					// the annotated node may have to grow its slice, which
+					// the compiler leaves a call to runtime.growslice for
+					// unless it proves the capacity suffices, if a map
+					// assignment may grow its map, or if those lines
+					// allocate in any other way.
+					end := fileSet.Position(info.n.End()).Line
+					found := append(allocations(k, line, end), growths(k, line, end)...)
+					if len(found) > 0 {
+						r.fail(info.n, d, "unexpected allocation: "+strings.Join(found, "; "))
+					}
+				case bcemerge:
+					// A bcemerge directive passes if the compiler merged
+					// the bounds checks on all of the lines of the annotated
+					// node into at most one.
+					found := 0
+					end := fileSet.Position(info.n.End()).Line
+					for l := line; l <= end; l++ {
+						found += boundsChecks[k][l]
+					}
+					if found > 1 {
+						r.fail(info.n, d, fmt.Sprintf("found %d bounds checks, expected at most one", found))
+					}
+				case maxtextsize:
+					// The text size of a function is reported in the header
+					// of its assembly listing, which is recorded at the
+					// line of its declaration.
+					limit, _ := strconv.Atoi(info.args[i])
+					size, ok := asm.textSizes[k][line]
+					if !ok {
+						r.fail(info.n, d, "no compiled function found for directive")
+					} else if size > limit {
+						r.fail(info.n, d, fmt.Sprintf("function is %d bytes, larger than the limit of %d", size, limit))
+					}
+				case cost:
+					// The inline cost of a function is reported with the
+					// compiler's inlining decision, at the line of its
+					// declaration.
+					limit, _ := strconv.Atoi(info.args[i])
+					message, ok := inlineMessages[k][line]
+					m := inlineCost.FindStringSubmatch(message)
+					if !ok {
+						r.fail(info.n, d, "no inline cost reported for directive")
+					} else if m == nil || strings.HasPrefix(message, "cannot inline ") {
+						r.fail(info.n, d, message)
+					} else if c, _ := strconv.Atoi(m[1]); c > limit {
+						r.fail(info.n, d, fmt.Sprintf("inline cost %d exceeds the limit of %d", c, limit))
+					}
+				case nocopy, inlineeq, callfree, staticitab, noselectgo:
+					// These directives fail on the first instruction that
+					// their asmCheck matches.
+					check := asmChecks[d]
+					end := line
+					if check.node {
+						end = fileSet.Position(info.n.End()).Line
+					}
+					for _, instr := range asm.matching(k, line, end, check.instr) {
+						if check.allow == nil || !check.allow.MatchString(instr) {
+							r.fail(info.n, d, check.message+": "+instr)
+							break
+						}
+					}
+				case nomorestack:
+					// Unless a function is nosplit, which the compiler
+					// infers for leaf functions with small frames, its
+					// prologue checks for room on the stack and calls
+					// runtime.morestack to grow it.
+					instrs, ok := asm.funcInstrs[k][line]
+					if !ok {
+						r.fail(info.n, d, "no compiled function found for directive")
+						break
+					}
+					for _, instr := range instrs {
+						if morestackInstr.MatchString(instr.text) {
+							r.fail(info.n, d, fmt.Sprintf("function may grow its stack, with a frame of %d bytes: %s",
+								asm.frameSizes[k][line], instr.text))
+							break
+						}
+					}
+				case typeassertmerge:
+					// Each type assertion that survives optimization calls
+					// the runtime when it fails, so a typeassertmerge
+					// directive passes if there's at most one such call on
+					// the lines of the annotated node.
+					found := asm.matching(k, line, fileSet.Position(info.n.End()).Line, typeAssertInstr)
+					if len(found) > 1 {
+						r.fail(info.n, d, fmt.Sprintf("found %d type assertion checks, expected at most one", len(found)))
+					}
+				case constfold:
+					if message := constFoldFailure(info.n, k, pkgs, fileSet, asm); message != "" {
+						r.fail(info.n, d, message)
+					}
+				case inlinebce:
+					if message := inlineBCEFailure(info.n, k, pkgs, fileSet, asm); message != "" {
+						r.fail(info.n, d, message)
+					}
+				case inlinenoalloc:
+					if message := inlineNoAllocFailure(info.n, k, pkgs, fileSet, asm); message != "" {
+						r.fail(info.n, d, message)
+					}
+				case mapfaststr:
+					// The compiler calls a runtime helper specialized for
+					// string keys unless the map's values are too large,
+					// which makes it fall back to the generic helper.
+					found := asm.matching(k, line, fileSet.Position(info.n.End()).Line, mapAccessInstr)
+					for _, instr := range found {
+						if mapAccessInstr.FindStringSubmatch(instr)[1] != "_faststr" {
+							r.fail(info.n, d, "map lookup doesn't use the faststr helper: "+instr)
+						}
+					}
+					if len(found) == 0 {
+						r.fail(info.n, d, "no map lookup found for directive")
+					}
+				case nospill:
+					if goarch != "amd64" {
+						r.fail(info.n, d, "stack spills can only be detected on amd64, not "+goarch)
+						break
+					}
+					if found := asm.matching(k, line, line, spillInstr); len(found) > 0 {
+						r.fail(info.n, d, "value spilled to the stack: "+found[0])
+					}
+				case register:
+					if goarch != "amd64" {
+						r.fail(info.n, d, "stack spills can only be detected on amd64, not "+goarch)
+						break
+					}
+					fd := enclosingFunc(pkgs, k, info.n)
+					if fd == nil {
+						r.fail(info.n, d, "directive must be attached to a variable in a function")
+						break
+					}
+					instrs, ok := asm.funcInstrs[k][fileSet.Position(fd.Pos()).Line]
+					if !ok {
+						r.fail(info.n, d, "no compiled function found for directive")
+						break
+					}
+					for _, instr := range instrs {
+						if varSpills[info.args[i]].MatchString(instr.text) {
+							r.fail(info.n, d, fmt.Sprintf("%s spilled to the stack: %s", info.args[i], instr.text))
+							break
+						}
+					}
+				case noretspill:
+					if goarch != "amd64" {
+						r.fail(info.n, d, "stack spills can only be detected on amd64, not "+goarch)
+						break
+					}
+					if message := retSpillFailure(asm.instrs[k][line]); message != "" {
+						r.fail(info.n, d, message)
+					}
+				}
+			}
+		}
+	}
+	// If 'go build' failed, return the error.
+	err = <-cmdErr
+	if f != nil && opts.DeleteLog {
+		if err == nil && len(r.failures) == 0 {
+			_ = os.Remove(f.Name())
+		} else if _, writeErr := fmt.Fprintf(opts.logw, "See %s for full output.\n", f.Name()); writeErr != nil {
+			return r.failures, checked, writeErr
+		}
+	}
+	if err != nil {
+		return r.failures, checked, &BuildError{Err: err}
+	}
+	return r.failures, checked, nil
+}
+
+// ignored returns whether message matches any of patterns.
+func ignored(patterns []*regexp.Regexp, message string) bool {
+	for _, p := range patterns {
+		if p.MatchString(message) {
+			return true
+		}
+	}
+	return false
+}
+
+// retSpillFailure returns why the results of the first call in instrs, the
+// amd64 instructions for a line, don't stay in registers after the call, or
+// the empty string if they do. A result that's too large for the result
+// registers is loaded from the stack frame, and a result that's live across
+// another call is stored to it.
+func retSpillFailure(instrs []string) string {
+	called := false
+	for _, instr := range instrs {
+		switch {
+		case !called:
+			called = strings.HasPrefix(instr, "CALL ")
+		case stackLoadInstr.MatchString(instr):
+			return "return value passed on the stack: " + instr
+		case spillInstr.MatchString(instr):
+			return "return value spilled to the stack: " + instr
+		}
+	}
+	return ""
+}
+
+// labelWriter prefixes everything written to it with label. Each failure is
+// written with a single call to Write, so each failure gets a label.
+type labelWriter struct {
+	w     io.Writer
+	label string
+}
+
+func (l *labelWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(l.w, l.label); err != nil {
+		return 0, err
+	}
+	return l.w.Write(p)
+}
+
+// noinlineFailure is the failure message for noinline directives. Small
+// functions are usually kept out of line so that they show up as their own
+// frame in CPU profiles.
+const noinlineFailure = "function was inlined, losing profiling boundary"
+
+var (
+	// asmFunc matches the header of each function in the assembly listing,
+	// and asmInstr matches each instruction and its source position.
+	asmFunc  = regexp.MustCompile(`^(\S+) STEXT.* size=(\d+) .*locals=(0x[0-9a-f]+)`)
+	asmInstr = regexp.MustCompile(`^\t0x[0-9a-f]+ \d+ \((.+):(\d+)\)\t(.*)$`)
+	// asmPseudoInstr matches instructions that don't compute anything, such
+	// as the marks that the compiler leaves where a call was inlined.
+	asmPseudoInstr = regexp.MustCompile(`^(PCDATA|FUNCDATA|NOP|XCHGL AX, AX)\b`)
+	// asmUnknownInstr matches an instruction without a source position.
+	asmUnknownInstr = regexp.MustCompile(`^\t0x[0-9a-f]+ \d+ \(<unknown line number>\)\t`)
+	// inlineCost matches the inline cost in the compiler's inlining
+	// decision for a function.
+	inlineCost = regexp.MustCompile(`\bcost (\d+)`)
+	// funcLitSymbol matches the symbols of func literals, which are named
+	// after the function that contains them.
+	funcLitSymbol = regexp.MustCompile(`\.func\d+(\.\d+)*$`)
+
+	// copyInstr matches instructions that copy a block of memory, rather than
+	// a value that fits in a few registers.
+	copyInstr = regexp.MustCompile(`^(DUFFCOPY|REP|CALL runtime\.(memmove|typedmemmove|wbMove)\(SB\))\b`)
+	// chanAlloc matches calls to the runtime function that allocates a
+	// channel on the heap.
+	chanAlloc = regexp.MustCompile(`^CALL runtime\.makechan(64)?\(SB\)$`)
+	// allocInstr matches calls to the runtime functions that allocate on the
+	// heap.
+	allocInstr = regexp.MustCompile(`^CALL runtime\.(newobject|mallocgc|makeslice\w*|makemap\w*|makechan(64)?|growslice|convT\w*)\(SB\)$`)
+	// eqHelperInstr matches calls to the functions that compare values that
+	// are too large or complex to compare inline.
+	eqHelperInstr = regexp.MustCompile(`^CALL (runtime\.(memequal\w*|efaceeq|ifaceeq|nilinterequal|interequal)|type:\.eq\.\S+)\(SB\)$`)
+	// mapAccessInstr matches the runtime calls that look up a key in a map,
+	// and the suffix of a helper that's specialized for the key's type.
+	mapAccessInstr = regexp.MustCompile(`^CALL runtime\.mapaccess[12](_\w+)?\(SB\)$`)
+	// boundsPanicInstr matches the runtime calls that panic when an index or
+	// slice expression is out of range.
+	boundsPanicInstr = regexp.MustCompile(`^CALL runtime\.(goP|p)anic(Index|Slice)\w*\(SB\)$`)
+	// mapAssignInstr matches the runtime calls that assign to a map, which
+	// grow the map when it's full.
+	mapAssignInstr = regexp.MustCompile(`^CALL runtime\.mapassign\w*\(SB\)$`)
+	// morestackInstr matches the call in a function's prologue that grows its
+	// stack.
+	morestackInstr = regexp.MustCompile(`^CALL runtime\.morestack\w*\(SB\)$`)
+	// runtimeCallInstr matches calls to runtime functions, and
+	// loopExitInstr matches the calls among them that record pointer writes
+	// for the garbage collector while it's marking, or that panic, which are
+	// on paths that leave a loop.
+	runtimeCallInstr = regexp.MustCompile(`^CALL runtime\.\w+\(SB\)$`)
+	loopExitInstr    = regexp.MustCompile(`^CALL runtime\.(gcWriteBarrier\w*|panic\w*)\(SB\)$`)
+	// selectInstr matches the runtime call that blocks on the cases of a
+	// select.
+	selectInstr = regexp.MustCompile(`^CALL runtime\.selectgo\(SB\)$`)
+	// growInstr matches the runtime call that grows a slice when an append
+	// exceeds its capacity.
+	growInstr = regexp.MustCompile(`^CALL runtime\.growslice\(SB\)$`)
+	// typeAssertInstr matches the runtime calls made by a type assertion that
+	// panics on failure: a panic for an assertion to a concrete type, or the
+	// lookup of an assertion to an interface type.
+	typeAssertInstr = regexp.MustCompile(`^CALL runtime\.(panicdottype[EI]|typeAssert|assertE2I)\(SB\)$`)
+	// itabLookupInstr matches the runtime calls that find the itab for a
+	// conversion to an interface type that isn't known at compile time.
+	itabLookupInstr = regexp.MustCompile(`^CALL runtime\.(getitab|typeAssert|assertE2I2?)\(SB\)$`)
+	// spillInstr matches amd64 instructions that store a register to the
+	// stack frame, either to pass a call argument that didn't fit in the
+	// argument registers or to spill a value.
+	spillInstr = regexp.MustCompile(`^MOV\w* [A-Z]\w*, \S*\(SP\)$`)
+	// stackLoadInstr matches amd64 instructions that load a register from the
+	// stack frame.
+	stackLoadInstr = regexp.MustCompile(`^MOV\w* \S*\(SP\), [A-Z]\w*$`)
+)
+
+// asmCheck describes how a directive is checked against the assembly listing.
+// The directives that fail on the first instruction that matches instr have
+// it set, and the others are checked by their own code.
+type asmCheck struct {
+	// instr matches the instructions that fail the directive, other than
+	// those that allow matches.
+	instr, allow *regexp.Regexp
+	// node is true if instr is looked for on every line of the annotated
+	// node, rather than only on the directive's line.
+	node bool
+	// message describes the failure, and is followed by the instruction.
+	message string
+}
+
+// asmChecks maps each directive that's checked against the assembly listing,
+// which the compiler prints with -S, to how it's checked.
+var asmChecks = map[assertDirective]asmCheck{
+	staticinit:      {},
+	allocs:          {},
+	noalloc:         {},
+	nospill:         {},
+	typeassertmerge: {},
+	nogrow:          {},
+	maxtextsize:     {},
+	constfold:       {},
+	inlinenoalloc:   {},
+	noretspill:      {},
+	mapfaststr:      {},
+	register:        {},
+	inlinebce:       {},
+	nomorestack:     {},
+	nocopy:          {instr: copyInstr, message: "struct copy was not elided"},
+	inlineeq:        {instr: eqHelperInstr, message: "comparison calls a runtime helper"},
+	// The calls in the loop body are checked as callsites, which leaves the
+	// runtime calls that the compiler generates for operations such as map
+	// accesses.
+	callfree: {instr: runtimeCallInstr, allow: loopExitInstr, node: true, message: "loop calls the runtime"},
+	// Converting a concrete type to an interface uses an itab that the
+	// compiler builds statically. Converting an interface to another one
+	// looks the itab up at run time, through a cache.
+	staticitab: {instr: itabLookupInstr, node: true, message: "itab is looked up at run time"},
+	// The compiler specializes a select with a single case, with or without
+	// a default, into a plain or non-blocking channel operation. Any other
+	// select calls runtime.selectgo.
+	noselectgo: {instr: selectInstr, node: true, message: "select was not specialized"},
+}
+
+// asmListing records the parts of the compiler's assembly listing (-S) that
+// directives are checked against.
+type asmListing struct {
+	resolver *pathResolver
+	// inFunc is true while the instructions of a function are being read,
+	// and inPkgInit is true if that function is a package's init function.
+	inFunc    bool
+	inPkgInit bool
+
+	// initLines maps filepath to the set of lines that have code in their
+	// package's init function.
+	initLines map[string]map[int]bool
+	// instrs maps filepath to line number to the instructions generated for
+	// that line, with whitespace normalized to single spaces.
+	instrs map[string]map[int][]string
+
+	// funcSize is the text size of the function being read, until it's
+	// recorded in textSizes at the position of the function's first
+	// instruction. It's -1 once recorded, or for a func literal.
+	funcSize int
+	// textSizes maps filepath to line number to the text size in bytes of the
+	// function declared on that line.
+	textSizes map[string]map[int]int
+
+	// funcPath and funcLine are the position of the first instruction of the
+	// function being read, which is the line that declares it.
+	funcPath string
+	funcLine int
+	// funcInstrs maps filepath to line number to the instructions of the
+	// function declared on that line, including those inlined into it from
+	// other functions.
+	funcInstrs map[string]map[int][]asmInstruction
+	// funcFrame is the frame size of the function being read, and
+	// frameSizes maps filepath to line number to the frame size in bytes of
+	// the function declared on that line.
+	funcFrame  int
+	frameSizes map[string]map[int]int
+}
+
+// asmInstruction is an instruction in the assembly listing and the source
+// position that it was generated for.
+type asmInstruction struct {
+	path string
+	line int
+	text string
+}
+
+func newAsmListing(resolver *pathResolver) *asmListing {
+	return &asmListing{
+		resolver:   resolver,
+		initLines:  make(map[string]map[int]bool),
+		instrs:     make(map[string]map[int][]string),
+		funcSize:   -1,
+		textSizes:  make(map[string]map[int]int),
+		funcInstrs: make(map[string]map[int][]asmInstruction),
+		frameSizes: make(map[string]map[int]int),
+	}
+}
+
+// matching returns the instructions generated for lines start to end of the
+// file at path that match re.
+func (a *asmListing) matching(path string, start, end int, re *regexp.Regexp) []string {
+	var found []string
+	for l := start; l <= end; l++ {
+		for _, instr := range a.instrs[path][l] {
+			if re.MatchString(instr) {
+				found = append(found, instr)
+			}
+		}
+	}
+	return found
+}
+
+// scan records line if it is part of the assembly listing, and returns whether
+// it was.
+func (a *asmListing) scan(line string) (bool, error) {
+	if matches := asmFunc.FindStringSubmatch(line); len(matches) != 0 {
+		a.inFunc = true
+		a.inPkgInit = strings.HasSuffix(matches[1], ".init")
+		a.funcPath, a.funcLine = "", 0
+		a.funcSize = -1
+		frame, err := strconv.ParseInt(matches[3], 0, 64)
+		if err != nil {
+			return false, err
+		}
+		a.funcFrame = int(frame)
+		if !funcLitSymbol.MatchString(matches[1]) {
+			size, err := strconv.Atoi(matches[2])
+			if err != nil {
+				return false, err
+			}
+			a.funcSize = size
+		}
+		return true, nil
+	}
+	if !a.inFunc {
+		return false, nil
+	}
+	if asmUnknownInstr.MatchString(line) {
+		// Instructions without a source position, such as padding, can
+		// appear anywhere in a function.
+		return true, nil
+	}
+	matches := asmInstr.FindStringSubmatch(line)
+	if len(matches) == 0 {
+		a.inFunc = false
+		return false, nil
+	}
+	path := a.resolver.resolve(matches[1])
+	lineNo, err := strconv.Atoi(matches[2])
+	if err != nil {
+		return false, err
+	}
+	instr := strings.Join(strings.Fields(matches[3]), " ")
+	if a.funcPath == "" {
+		a.funcPath, a.funcLine = path, lineNo
+		if a.funcInstrs[path] == nil {
+			a.funcInstrs[path] = make(map[int][]asmInstruction)
+			a.frameSizes[path] = make(map[int]int)
+		}
+		a.frameSizes[path][lineNo] = a.funcFrame
+	}
+	a.funcInstrs[a.funcPath][a.funcLine] = append(a.funcInstrs[a.funcPath][a.funcLine],
+		asmInstruction{path: path, line: lineNo, text: instr})
+	if a.inPkgInit {
+		if a.initLines[path] == nil {
+			a.initLines[path] = make(map[int]bool)
+		}
+		a.initLines[path][lineNo] = true
+	}
+	if a.funcSize >= 0 {
+		if a.textSizes[path] == nil {
+			a.textSizes[path] = make(map[int]int)
+		}
+		a.textSizes[path][lineNo] = a.funcSize
+		a.funcSize = -1
+	}
+	if a.instrs[path] == nil {
+		a.instrs[path] = make(map[int][]string)
+	}
+	a.instrs[path][lineNo] = append(a.instrs[path][lineNo], instr)
+	return true, nil
+}
+
+// escapedSize returns the size of the heap allocation caused by the escape of
+// the expression at line and col of the file at path, if it's known. That's the
+// size of a variable that's moved to the heap, or of the value that an escaping
+// pointer points to. The size of other allocations, such as the backing array
+// of a slice, depends on values that are only known at run time.
+func escapedSize(pkgs []*packages.Package, fileSet *token.FileSet, path string, line, col int) (int64, bool) {
+	for _, pkg := range pkgs {
+		for i, file := range pkg.Syntax {
+			if pkg.CompiledGoFiles[i] != path {
+				continue
+			}
+			var typ types.Type
+			ast.Inspect(file, func(n ast.Node) bool {
+				if typ != nil || n == nil {
+					return false
+				}
+				expr, ok := n.(ast.Expr)
+				if !ok {
+					return true
+				}
+				if pos := fileSet.Position(expr.Pos()); pos.Line != line || pos.Column != col {
+					return true
+				}
+				switch expr := expr.(type) {
+				case *ast.Ident:
+					if obj := pkg.TypesInfo.ObjectOf(expr); obj != nil {
+						typ = obj.Type()
+					}
+				case *ast.UnaryExpr:
+					if ptr, ok := pkg.TypesInfo.TypeOf(expr).(*types.Pointer); ok && expr.Op == token.AND {
+						typ = ptr.Elem()
+					}
+				case *ast.CompositeLit:
+					typ = pkg.TypesInfo.TypeOf(expr)
+				}
+				return typ == nil
+			})
+			if typ == nil {
+				return 0, false
+			}
+			switch typ.Underlying().(type) {
+			case *types.Pointer, *types.Slice, *types.Map, *types.Chan, *types.Signature, *types.Interface:
+				// The variable refers to the allocation, rather than being it.
+				return 0, false
+			}
+			return pkg.TypesSizes.Sizeof(typ), true
+		}
+	}
+	return 0, false
+}
+
+// escapeFailure returns the failure message of a noescape directive for
+// message, an escape that the compiler reported on line lineNo and column colNo
+// of the file at path, with the size of the allocation that the escape causes,
+// which the compiler doesn't report, if it's known.
+func escapeFailure(pkgs []*packages.Package, fileSet *token.FileSet, path string, lineNo, colNo int, message string) string {
+	if size, ok := escapedSize(pkgs, fileSet, path, lineNo, colNo); ok && strings.HasSuffix(message, ":") {
+		name := strings.TrimSuffix(message, " escapes to heap:")
+		return fmt.Sprintf("%s %s (%d bytes)", message, name, size)
+	}
+	return message
+}
+
+// noescapeFunc is a function with a noescape directive, whose parameters and
+// receiver, declared at the lines and columns in params, must not escape on
+// any of its lines, from start to end.
+type noescapeFunc struct {
+	decl       *ast.FuncDecl
+	start, end int
+	params     map[[2]int]bool
+}
+
+// noescapeFuncs returns the functions with a noescape directive in
+// directiveMap, keyed by filepath.
+func noescapeFuncs(directiveMap directiveMap, fileSet *token.FileSet) map[string][]noescapeFunc {
+	funcs := make(map[string][]noescapeFunc)
+	for path, lines := range directiveMap {
+		for _, info := range lines {
+			decl, ok := info.n.(*ast.FuncDecl)
+			if !ok || !info.has(noescape) {
+				continue
+			}
+			fn := noescapeFunc{
+				decl:   decl,
+				start:  fileSet.Position(decl.Pos()).Line,
+				end:    fileSet.Position(decl.End()).Line,
+				params: make(map[[2]int]bool),
+			}
+			for _, fields := range []*ast.FieldList{decl.Recv, decl.Type.Params} {
+				if fields == nil {
+					continue
+				}
+				for _, field := range fields.List {
+					for _, name := range field.Names {
+						pos := fileSet.Position(name.Pos())
+						fn.params[[2]int{pos.Line, pos.Column}] = true
+					}
+				}
+			}
+			funcs[path] = append(funcs[path], fn)
+		}
+	}
+	return funcs
+}
+
+// escapesVar returns whether message, one of the compiler's escape analysis
+// messages, says that a variable escapes to the heap or is leaked.
+func escapesVar(message string) bool {
+	return strings.HasSuffix(message, " escapes to heap:") || strings.HasPrefix(message, "leaking param: ")
+}
+
+// escapeTrace is a failed noescape directive, attached to node n in the file at
+// path, that's traced with the escape analysis of its function.
+type escapeTrace struct {
+	// failure is the index of the failure in the reporter's failures.
+	failure int
+	path    string
+	n       ast.Node
+}
+
+// enclosingFunc returns the declaration of the function that contains n, which
+// is in the file at path, or nil if n isn't in a function.
+func enclosingFunc(pkgs []*packages.Package, path string, n ast.Node) *ast.FuncDecl {
+	for _, pkg := range pkgs {
+		for i, file := range pkg.Syntax {
+			if pkg.CompiledGoFiles[i] != path {
+				continue
+			}
+			for _, decl := range file.Decls {
+				if fd, ok := decl.(*ast.FuncDecl); ok && fd.Pos() <= n.Pos() && n.End() <= fd.End() {
+					return fd
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// analyzable returns whether node n in the file at path has an expression that
+// directive d, either bce or noescape, can apply to: an index or slice
+// expression for bce, or a variable, address, literal or allocation for
+// noescape.
+func analyzable(pkgs []*packages.Package, path string, n ast.Node, d assertDirective) bool {
+	for _, pkg := range pkgs {
+		for i := range pkg.Syntax {
+			if pkg.CompiledGoFiles[i] != path {
+				continue
+			}
+			found := false
+			ast.Inspect(n, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.IndexExpr:
+					// Indexing a map has no bounds check, and neither does
+					// instantiating a generic function.
+					typ := pkg.TypesInfo.TypeOf(n.X)
+					if ptr, ok := typ.(*types.Pointer); ok {
+						typ = ptr.Elem()
+					}
+					switch typ.Underlying().(type) {
+					case *types.Slice, *types.Array, *types.Basic:
+						found = d == bce
+					}
+				case *ast.SliceExpr:
+					found = d == bce
+				case *ast.Ident:
+					_, isVar := pkg.TypesInfo.Defs[n].(*types.Var)
+					found = isVar && d == noescape
+				case *ast.UnaryExpr:
+					found = n.Op == token.AND && d == noescape
+				case *ast.CompositeLit, *ast.FuncLit:
+					found = d == noescape
+				case *ast.CallExpr:
+					if id, ok := n.Fun.(*ast.Ident); ok && (id.Name == "make" || id.Name == "new") {
+						_, isBuiltin := pkg.TypesInfo.Uses[id].(*types.Builtin)
+						found = isBuiltin && d == noescape
+					}
+				}
+				return !found
+			})
+			return found
+		}
+	}
+	// Without the file's syntax, the directive can't be shown to be
+	// misplaced.
+	return true
+}
+
+// inlinedCall is a call to a function declared in the loaded packages, and the
+// instructions of the function that makes it.
+type inlinedCall struct {
+	// name is the name of the called function.
+	name string
+	// path is the file containing the call, which is on lines callStart to
+	// callEnd, and calleePath is the file containing the callee, whose body
+	// is on lines bodyStart to bodyEnd.
+	path, calleePath   string
+	callStart, callEnd int
+	bodyStart, bodyEnd int
+	instrs             []asmInstruction
+}
+
+// findInlinedCall returns the first call in n, which is in the file at path,
+// or why it can't be checked.
+func findInlinedCall(n ast.Node, path string, pkgs []*packages.Package, fileSet *token.FileSet, asm *asmListing) (inlinedCall, string) {
+	var call *ast.CallExpr
+	ast.Inspect(n, func(n ast.Node) bool {
+		if c, ok := n.(*ast.CallExpr); ok && call == nil {
+			call = c
+		}
+		return call == nil
+	})
+	if call == nil {
+		return inlinedCall{}, "directive must be attached to a function call"
+	}
+
+	caller := enclosingFunc(pkgs, path, call)
+	var callee *ast.FuncDecl
+	var fn *types.Func
+	for _, pkg := range pkgs {
+		for i := range pkg.Syntax {
+			if pkg.CompiledGoFiles[i] != path {
+				continue
+			}
+			switch fun := call.Fun.(type) {
+			case *ast.Ident:
+				fn, _ = pkg.TypesInfo.Uses[fun].(*types.Func)
+			case *ast.SelectorExpr:
+				fn, _ = pkg.TypesInfo.Uses[fun.Sel].(*types.Func)
+			}
+		}
+	}
+	if caller == nil || fn == nil {
+		return inlinedCall{}, "directive must be attached to a call to a function, inside a function"
+	}
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Syntax {
+			for _, decl := range file.Decls {
+				if fd, ok := decl.(*ast.FuncDecl); ok && fd.Name.Pos() == fn.Pos() {
+					callee = fd
+				}
+			}
+		}
+	}
+	if callee == nil || callee.Body == nil {
+		return inlinedCall{}, fmt.Sprintf("the source of %s isn't loaded", fn.Name())
+	}
+
+	callerPos := fileSet.Position(caller.Pos())
+	instrs, ok := asm.funcInstrs[callerPos.Filename][callerPos.Line]
+	if !ok {
+		return inlinedCall{}, "no compiled function found for directive"
+	}
+	return inlinedCall{
+		name:       fn.Name(),
+		path:       path,
+		calleePath: fileSet.Position(callee.Pos()).Filename,
+		callStart:  fileSet.Position(call.Pos()).Line,
+		callEnd:    fileSet.Position(call.End()).Line,
+		bodyStart:  fileSet.Position(callee.Body.Pos()).Line,
+		bodyEnd:    fileSet.Position(callee.Body.End()).Line,
+		instrs:     instrs,
+	}, ""
+}
+
+// notInlined returns whether instr calls the callee from the call's lines.
+func (c inlinedCall) notInlined(instr asmInstruction) bool {
+	return instr.path == c.path && c.callStart <= instr.line && instr.line <= c.callEnd &&
+		strings.HasPrefix(instr.text, "CALL ") && strings.HasSuffix(instr.text, "."+c.name+"(SB)")
+}
+
+// inCallee returns whether instr was generated for the callee's body.
+func (c inlinedCall) inCallee(instr asmInstruction) bool {
+	return instr.path == c.calleePath && c.bodyStart <= instr.line && instr.line <= c.bodyEnd
+}
+
+// constFoldFailure returns why the first call in n, which is in the file at
+// path, didn't fold to a constant after it was inlined, or the empty string if
+// it did. The call folded if no instructions generated for the callee's body
+// remain in the calling function.
+func constFoldFailure(n ast.Node, path string, pkgs []*packages.Package, fileSet *token.FileSet, asm *asmListing) string {
+	call, message := findInlinedCall(n, path, pkgs, fileSet, asm)
+	if message != "" {
+		return message
+	}
+	for _, instr := range call.instrs {
+		if call.notInlined(instr) {
+			return "call was not inlined"
+		}
+		if call.inCallee(instr) && !asmPseudoInstr.MatchString(instr.text) {
+			return "inlined call wasn't folded to a constant: " + instr.text
+		}
+	}
+	return ""
+}
+
+// inlineBCEFailure returns why the first call in n, which is in the file at
+// path, still bounds checks after it was inlined, or the empty string if it
+// doesn't. The compiler reports bounds checks at the position of the index
+// expression, which is in the callee's body, so the calling function's
+// assembly listing is searched for the panic calls of failed checks instead.
+func inlineBCEFailure(n ast.Node, path string, pkgs []*packages.Package, fileSet *token.FileSet, asm *asmListing) string {
+	call, message := findInlinedCall(n, path, pkgs, fileSet, asm)
+	if message != "" {
+		return message
+	}
+	for _, instr := range call.instrs {
+		if call.notInlined(instr) {
+			return "call was not inlined"
+		}
+	}
+	for _, instr := range call.instrs {
+		if call.inCallee(instr) && boundsPanicInstr.MatchString(instr.text) {
+			return "inlined call is bounds checked: " + instr.text
+		}
+	}
+	return ""
+}
+
+// inlineNoAllocFailure returns why the first call in n, which is in the file at
+// path, still allocates on the heap after it was inlined, or the empty string
+// if it doesn't. Once inlined, an allocation in the callee is made by the
+// calling function, at the callee's position, unless escape analysis of the
+// caller keeps it on the stack.
+func inlineNoAllocFailure(n ast.Node, path string, pkgs []*packages.Package, fileSet *token.FileSet, asm *asmListing) string {
+	call, message := findInlinedCall(n, path, pkgs, fileSet, asm)
+	if message != "" {
+		return message
+	}
+	for _, instr := range call.instrs {
+		if call.notInlined(instr) {
+			return "call was not inlined"
+		}
+	}
+	for _, instr := range call.instrs {
+		if (call.inCallee(instr) || instr.path == path && call.callStart <= instr.line && instr.line <= call.callEnd) &&
+			allocInstr.MatchString(instr.text) {
+			return "allocation remains after inlining: " + instr.text
+		}
+	}
+	return ""
+}
+
+// pathResolver maps the file paths in the compiler's output to the paths of
+// the loaded files, which are the keys of a directiveMap.
+type pathResolver struct {
+	cwd string
+	// trimmed maps the path that the compiler prints for a file when building
+	// with -trimpath, which is its package's import path joined with the file
+	// name, to the file's path.
+	trimmed map[string]string
+	// files are the paths of the loaded files.
+	files map[string]bool
+}
+
+func newPathResolver(cwd string, pkgs []*packages.Package) *pathResolver {
+	p := &pathResolver{cwd: cwd, trimmed: make(map[string]string), files: make(map[string]bool)}
+	for _, pkg := range pkgs {
+		for _, file := range pkg.CompiledGoFiles {
+			p.trimmed[pkg.PkgPath+"/"+filepath.Base(file)] = file
+			p.files[file] = true
+		}
+	}
+	return p
+}
+
+// resolve returns the path of the file that the compiler printed as path.
+func (p *pathResolver) resolve(path string) string {
+	if filepath.IsAbs(path) {
+		// Clean the path, so that it's the same as the path that the
+		// file was loaded with.
+		return filepath.Clean(path)
+	}
+	if file, ok := p.trimmed[path]; ok {
+		return file
+	}
+	joined := filepath.Join(p.cwd, path)
+	if !p.files[joined] {
+		// The go command replays the output of a cached build with paths
+		// relative to the directory that it was built in, which may not
+		// be cwd, so find the only loaded file that path is a suffix of.
+		suffix := string(filepath.Separator) + filepath.Clean(path)
+		found := ""
+		for file := range p.files {
+			if strings.HasSuffix(file, suffix) {
+				if found != "" {
+					return joined
+				}
+				found = file
+			}
+		}
+		if found != "" {
+			return found
+		}
+	}
+	return joined
+}
+
+// outputCache stores the compiler output of each package in a directory, so
+// that a package that hasn't changed since a previous run doesn't have to be
+// built again. The output is keyed by a hash of the package's import path, the
+// contents of its files, and the flags that it's built with. Changes to the
+// package's dependencies don't change the key, so the cache should be
+// cleared when they change.
+type outputCache struct {
+	dir string
+	// keys maps the import path of each package to its key.
+	keys map[string]string
+}
+
+func newOutputCache(dir string, pkgs []*packages.Package, flags []string) (*outputCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := &outputCache{dir: dir, keys: make(map[string]string)}
+	for _, pkg := range pkgs {
+		h := sha256.New()
+		for _, flag := range flags {
+			fmt.Fprintf(h, "%q\n", flag)
+		}
+		fmt.Fprintf(h, "%q\n", pkg.PkgPath)
+		for _, file := range pkg.CompiledGoFiles {
+			src, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(h, "%q %d\n", file, len(src))
+			h.Write(src)
+		}
+		c.keys[pkg.PkgPath] = hex.EncodeToString(h.Sum(nil))
+	}
+	return c, nil
+}
+
+// get returns the cached output of the package with import path pkgPath, if
+// there is any.
+func (c *outputCache) get(pkgPath string) ([]string, bool) {
+	b, err := os.ReadFile(filepath.Join(c.dir, c.keys[pkgPath]))
+	if err != nil {
+		return nil, false
+	}
+	if len(b) == 0 {
+		return nil, true
+	}
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n"), true
+}
+
+// put caches the output of building the packages with import paths
+// pkgPaths. The go command prints the output of each package after a
+// "# importpath" header.
+func (c *outputCache) put(pkgPaths []string, out string) error {
+	outputs := make(map[string]*strings.Builder)
+	for _, pkgPath := range pkgPaths {
+		outputs[pkgPath] = &strings.Builder{}
+	}
+	var cur *strings.Builder
+	for _, line := range strings.SplitAfter(out, "\n") {
+		if pkgPath, ok := strings.CutPrefix(line, "# "); ok {
+			cur = outputs[strings.TrimSpace(pkgPath)]
+		}
+		if cur != nil {
+			cur.WriteString(line)
+		}
+	}
+	for pkgPath, b := range outputs {
+		// Write the output to a temporary file first, so that a run that
+		// fails part way doesn't leave a partial entry behind.
+		tmp, err := os.CreateTemp(c.dir, "tmp-*")
+		if err != nil {
+			return err
+		}
+		if _, err := tmp.WriteString(b.String()); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp.Name(), filepath.Join(c.dir, c.keys[pkgPath])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Failure describes a //gcassert directive that the compiler didn't uphold,
+// or that couldn't be parsed.
+type Failure struct {
+	// File is the path of the file containing the directive, relative to
+	// the working directory of the run if possible.
+	File string `json:"file"`
+	// Line and Col are the position of the AST node that the directive is
+	// attached to, or for bce and noescape failures, the position of the
+	// bounds check or escape that the compiler reported, which distinguishes
+	// it from the others in the node.
+	Line int `json:"line"`
+	Col  int `json:"column"`
+	// Directive is the name of the directive that failed, such as "bce". It
+	// is empty if the directive couldn't be parsed.
+	Directive string `json:"directive"`
+	// Message explains the failure, usually with the compiler output that
+	// proved that the directive failed.
+	Message string `json:"message"`
+	// Source is the printed source of the AST node that the directive is
+	// attached to.
+	Source string `json:"source"`
+	// Trace is the compiler output that explains the failure in more detail,
+	// such as the escape analysis of the function that contains the
+	// directive when Options.EscapeTrace is set. Each entry is a compiler
+	// message prefixed with its position.
+	Trace []string `json:"trace,omitempty"`
+	// Warning is set if the failure doesn't fail the run, because its
+	// directive isn't listed in Options.FailOn.
+	Warning bool `json:"warning,omitempty"`
+
+	// compilerPos is set if Line and Col are the position that the compiler
+	// reported, in which case String includes the column.
+	compilerPos bool
+	// nodeLine and nodeCol are the position of the AST node that the
+	// directive is attached to, which identifies the directive when it
+	// fails more than once.
+	nodeLine, nodeCol int
+}
+
+func (f Failure) String() string {
+	var b strings.Builder
+	if f.compilerPos {
+		fmt.Fprintf(&b, "%s:%d:%d:\t%s: %s", f.File, f.Line, f.Col, f.Source, f.Message)
+	} else {
+		fmt.Fprintf(&b, "%s:%d:\t%s: %s", f.File, f.Line, f.Source, f.Message)
+	}
+	for _, t := range f.Trace {
+		b.WriteString("\n\t")
+		b.WriteString(t)
+	}
+	return b.String()
+}
+
+// writeFailures writes each failure to w on its own line.
+func writeFailures(w io.Writer, failures []Failure) error {
+	for _, f := range failures {
+		if _, err := fmt.Fprintln(w, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reporter collects the failures of a gcassert run.
+type reporter struct {
+	cwd string
+	// root is the directory that the paths of failures are relative to. If
+	// it's empty, they're absolute.
+	root    string
+	fileSet *token.FileSet
+	rewrite func(directive, message string) string
+	// lines, if not empty, are the only lines that failures are reported
+	// on.
+	lines    []LineRange
+	failures []Failure
+}
+
+// inLines returns whether n overlaps one of the reporter's lines.
+func (r *reporter) inLines(n ast.Node) bool {
+	start, end := r.fileSet.Position(n.Pos()), r.fileSet.Position(n.End())
+	for _, l := range r.lines {
+		path := l.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(r.cwd, path)
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		if path == start.Filename && l.Start <= end.Line && start.Line <= l.End {
+			return true
+		}
+	}
+	return false
+}
+
+// fail records a failure of directive d, which is attached to node n.
+func (r *reporter) fail(n ast.Node, d assertDirective, message string) {
+	reported := len(r.failures)
+	r.record(n, d, message)
+	r.dedupe(reported)
+}
+
+// record adds a failure of directive d, which is attached to node n, unless
+// n is outside the reporter's lines.
+func (r *reporter) record(n ast.Node, d assertDirective, message string) {
+	if len(r.lines) > 0 && !r.inLines(n) {
+		return
+	}
+	var buf strings.Builder
+	if c, ok := n.(*ast.Comment); ok {
+		// The printer only prints comments as part of the nodes they're
+		// attached to.
+		buf.WriteString(c.Text)
+	} else {
+		_ = printer.Fprint(&buf, r.fileSet, n)
+	}
+	pos := r.fileSet.Position(n.Pos())
+	relPath := pos.Filename
+	if r.root != "" {
+		if rel, err := filepath.Rel(r.root, pos.Filename); err == nil {
+			relPath = rel
+		}
+	}
+	if r.rewrite != nil {
+		message = r.rewrite(d.String(), message)
+	}
+	r.failures = append(r.failures, Failure{
+		File:      relPath,
+		Line:      pos.Line,
+		Col:       pos.Column,
+		Directive: d.String(),
+		Message:   message,
+		Source:    buf.String(),
+		nodeLine:  pos.Line,
+		nodeCol:   pos.Column,
+	})
+}
+
+// failAt records a failure of directive d, which is attached to node n, at the
+// position that the compiler reported on line lineNo and column colNo.
+func (r *reporter) failAt(n ast.Node, d assertDirective, message string, lineNo, colNo int) {
+	reported := len(r.failures)
+	r.record(n, d, message)
+	if len(r.failures) > reported {
+		f := &r.failures[reported]
+		f.Line, f.Col, f.compilerPos = lineNo, colNo, true
+	}
+	r.dedupe(reported)
+}
+
+// dedupe removes the failure at index i, if there is one, if it's the same as
+// an earlier failure: of the same directive at the same position, with the
+// same message. The compiler can print the same message more than once, such
+// as once for each instantiation of a generic function, or each function that
+// a call is inlined into. Failures that differ in any of these are kept, even
+// if they're on the same line.
+func (r *reporter) dedupe(i int) {
+	if i >= len(r.failures) {
+		return
+	}
+	f := r.failures[i]
+	for _, prev := range r.failures[:i] {
+		if prev.File == f.File && prev.Line == f.Line && prev.Col == f.Col &&
+			prev.Directive == f.Directive && prev.Message == f.Message {
+			r.failures = r.failures[:i]
+			return
+		}
+	}
+}
+
+// directiveMap maps filepath to line number to lineInfo
+type directiveMap map[string]map[int]lineInfo
+
+// args returns the distinct arguments of directive d on every line in the map,
+// in sorted order.
+func (m directiveMap) args(d assertDirective) []string {
+	seen := make(map[string]bool)
+	var args []string
+	for _, lineToDirectives := range m {
+		for _, info := range lineToDirectives {
+			for i, directive := range info.directives {
+				if directive == d && !seen[info.args[i]] {
+					seen[info.args[i]] = true
+					args = append(args, info.args[i])
+				}
+			}
+		}
+	}
+	sort.Strings(args)
+	return args
+}
+
+// count returns the number of directives in the map. Each call to a function
+// with an inline or noinline directive counts as a directive of its own.
+func (m directiveMap) count() int {
+	n := 0
+	for _, lineToDirectives := range m {
+		for _, info := range lineToDirectives {
+			n += len(info.directives)
+			for _, cs := range info.inlinableCallsites {
+				if !cs.callfree {
+					n++
+				}
+			}
+		}
+	}
+	return n
+}
+
+// needsAsm returns whether any directive in the map is checked against the
+// assembly listing.
+func (m directiveMap) needsAsm() bool {
+	for _, lineToDirectives := range m {
+		for _, info := range lineToDirectives {
+			for _, directive := range info.directives {
+				if _, ok := asmChecks[directive]; ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// has returns whether any line in the map is annotated with directive d.
+func (m directiveMap) has(d assertDirective) bool {
+	for _, lineToDirectives := range m {
+		for _, info := range lineToDirectives {
+			for _, directive := range info.directives {
+				if directive == d {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// externalDirectives maps filepath to line number to the comma-separated
+// directives for that line, as read from a directives file.
+type externalDirectives map[string]map[int]string
+
+// readDirectivesFile reads the directives file at path, in the format described
+// by Options.DirectivesFile.
+func readDirectivesFile(path string) (externalDirectives, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	external := make(externalDirectives)
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		loc, directives, ok := strings.Cut(line, " ")
+		file, lineNo, ok2 := strings.Cut(loc, ":")
+		n, err := strconv.Atoi(lineNo)
+		if !ok || !ok2 || err != nil {
+			return nil, fmt.Errorf("%s:%d: malformed line %q, expected a file:line position and directives", path, i+1, line)
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		if external[file] == nil {
+			external[file] = make(map[int]string)
+		}
+		external[file][n] = strings.TrimSpace(directives)
+	}
+	return external, nil
+}
+
+// forEachPackage calls f for each package in pkgs, concurrently on up to
+// GOMAXPROCS goroutines. Each call reports to its own reporter, and the
+// failures are added to r in the order of pkgs, so that they don't depend on
+// the order in which the calls finish.
+func forEachPackage(pkgs []*packages.Package, r *reporter, f func(pkg *packages.Package, r *reporter)) {
+	reporters := make([]*reporter, len(pkgs))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				reporters[i] = &reporter{cwd: r.cwd, root: r.root, fileSet: r.fileSet, rewrite: r.rewrite, lines: r.lines}
+				f(pkgs[i], reporters[i])
+			}
+		}()
+	}
+	for i := range pkgs {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	for _, pr := range reporters {
+		r.failures = append(r.failures, pr.failures...)
+	}
+}
+
+// parseDirectives finds the directives in the comments of pkgs, and those in
+// external, a map from filepath to line number to directives read from a
+// directives file. It's an error if any of the external directives aren't on a
+// line of code in pkgs. It also returns the functions with an inline or
+// inlinedeep directive that are never called in pkgs, so their directives are
+// never checked.
+func parseDirectives(pkgs []*packages.Package, fileSet *token.FileSet, r *reporter, external externalDirectives) (directiveMap, map[types.Object]assertDirective, error) {
+	fileDirectiveMap := make(directiveMap)
+	inlineFuncs := make(map[types.Object]assertDirective)
+	// unused records the external directives that weren't attached to a node.
+	unused := make(externalDirectives)
+	for file, lines := range external {
+		unused[file] = lines
+	}
+	// mu guards fileDirectiveMap and unused, and inlineFuncs during the first
+	// pass.
+	var mu sync.Mutex
+	forEachPackage(pkgs, r, func(pkg *packages.Package, r *reporter) {
+		pkgDirectiveMap := make(directiveMap)
+		pkgInlineFuncs := make(map[types.Object]assertDirective)
+		for i, file := range pkg.Syntax {
+			commentMap := ast.NewCommentMap(fileSet, file, file.Comments)
+
+			v := newAssertVisitor(commentMap, fileSet, pkg, pkgInlineFuncs, r)
+			path := pkg.CompiledGoFiles[i]
+			if lines := external[path]; lines != nil {
+				v.external = make(map[int]string, len(lines))
+				for line, directives := range lines {
+					v.external[line] = directives
+				}
+			}
+			// First: find all lines of code annotated with our gcassert directives.
+			ast.Walk(&v, file)
+
+			if len(v.directiveMap) > 0 {
+				pkgDirectiveMap[path] = v.directiveMap
+			}
+			if v.external != nil {
+				mu.Lock()
+				unused[path] = v.external
+				mu.Unlock()
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for file, lines := range pkgDirectiveMap {
+			fileDirectiveMap[file] = lines
+		}
+		for obj, d := range pkgInlineFuncs {
+			inlineFuncs[obj] = d
+		}
+	})
+	var missing []string
+	for file, lines := range unused {
+		for line, directives := range lines {
+			missing = append(missing, fmt.Sprintf("%s:%d: directives %q aren't on a line of code in the loaded packages",
+				file, line, directives))
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, nil, errors.New(strings.Join(missing, "\n"))
+	}
+
+	// Collect the type arguments of every instantiation of a generic
+	// function, so that calls to methods of a type parameter's constraint can
+	// be resolved to the concrete methods that they call.
+	instances := make(map[types.Object][]*types.TypeList)
+	for _, pkg := range pkgs {
+		for ident, inst := range pkg.TypesInfo.Instances {
+			if fn, ok := pkg.TypesInfo.Uses[ident].(*types.Func); ok {
+				instances[fn.Origin()] = append(instances[fn.Origin()], inst.TypeArgs)
+			}
+		}
+	}
+
+	// Do another pass to find all callsites of funcs marked with inline. It
+	// needs the inline-asserted funcs of every package, so it can't start
+	// until the first pass is done.
+	nested := make(map[types.Object][]string)
+	called := make(map[types.Object]bool)
+	forEachPackage(pkgs, r, func(pkg *packages.Package, r *reporter) {
+		for i, file := range pkg.Syntax {
+			v := &inlinedDeclVisitor{
+				assertVisitor: newAssertVisitor(nil, fileSet, pkg, inlineFuncs, r),
+				instances:     instances,
+				funcValues:    funcValues(file, pkg.TypesInfo),
+				nested:        make(map[types.Object][]string),
+				stmts:         make(map[*ast.CallExpr]string),
+				called:        make(map[types.Object]bool),
+			}
+			filePath := pkg.CompiledGoFiles[i]
+			mu.Lock()
+			v.directiveMap = fileDirectiveMap[filePath]
+			mu.Unlock()
+			if v.directiveMap == nil {
+				v.directiveMap = make(map[int]lineInfo)
+			}
+			ast.Walk(v, file)
+			mu.Lock()
+			if len(v.directiveMap) > 0 {
+				fileDirectiveMap[filePath] = v.directiveMap
+			}
+			for obj, names := range v.nested {
+				nested[obj] = names
+			}
+			for obj := range v.called {
+				called[obj] = true
+			}
+			mu.Unlock()
+		}
+	})
+	// The callsites of an inlinedeep function can't be given the calls in
+	// its body until every file has been visited.
+	for _, lineToDirectives := range fileDirectiveMap {
+		for _, info := range lineToDirectives {
+			for i := range info.inlinableCallsites {
+				cs := &info.inlinableCallsites[i]
+				if cs.deep != nil {
+					cs.nested = nested[cs.deep]
+				}
+			}
+		}
+	}
+	uncalled := make(map[types.Object]assertDirective)
+	for obj, d := range inlineFuncs {
+		if (d == inline || d == inlinedeep) && !called[obj] {
+			uncalled[obj] = d
+		}
+	}
+	return fileDirectiveMap, uncalled, nil
+}
+
+type inlinedDeclVisitor struct {
+	assertVisitor
+
+	// instances maps generic functions to the type arguments of each of
+	// their instantiations.
+	instances map[types.Object][]*types.TypeList
+	// funcValues maps the local variables of the file that are only
+	// assigned when they're declared to their values.
+	funcValues map[*types.Var]ast.Expr
+	// nested maps the inlinedeep functions declared in the file to the
+	// names of the inline-asserted functions that they call.
+	nested map[types.Object][]string
+	// stmts maps the calls of the file's defer and go statements to the
+	// statement's keyword.
+	stmts map[*ast.CallExpr]string
+	// called records the inline-asserted functions that the file calls.
+	called map[types.Object]bool
+}
+
+// resolveConstraintMethod returns the inline-asserted concrete methods that a
+// call to method, a method of the constraint of type parameter tp, dispatches
+// to in the instantiations of tp's generic function, in the order they're
+// declared, since each instantiation's call is checked. If there are no such
+// methods, it returns method.
+func (v *inlinedDeclVisitor) resolveConstraintMethod(tp *types.TypeParam, method types.Object) []types.Object {
+	var methods []types.Object
+	seen := make(map[types.Object]bool)
+	for fn, typeArgLists := range v.instances {
+		tparams := fn.Type().(*types.Signature).TypeParams()
+		if tp.Index() >= tparams.Len() || tparams.At(tp.Index()) != tp {
+			continue
+		}
+		for _, typeArgs := range typeArgLists {
+			concrete, _, _ := types.LookupFieldOrMethod(typeArgs.At(tp.Index()), true, method.Pkg(), method.Name())
+			if fn, ok := concrete.(*types.Func); ok {
+				concrete = fn.Origin()
+			}
+			if _, ok := v.inlineFuncs[concrete]; ok && !seen[concrete] {
+				seen[concrete] = true
+				methods = append(methods, concrete)
+			}
+		}
+	}
+	if len(methods) == 0 {
+		return []types.Object{method}
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].Pos() < methods[j].Pos() })
+	return methods
+}
+
+// resolvePromotedMethod returns the method that sel, the selection of a method
+// promoted from a field embedded in x, calls. If the method is promoted from
+// an interface, that's the method of the concrete type stored in the field,
+// which is only known if x is a struct literal, or a local variable that's only
+// assigned one, whose field has a concrete type. Otherwise, it returns nil.
+func (v *inlinedDeclVisitor) resolvePromotedMethod(x ast.Expr, sel *types.Selection) types.Object {
+	method := sel.Obj()
+	recv := method.Type().(*types.Signature).Recv()
+	if recv == nil || !types.IsInterface(recv.Type()) {
+		return method
+	}
+	// Only an interface embedded in x's own struct type is resolved.
+	if len(sel.Index()) != 2 {
+		return nil
+	}
+	lit := v.structLit(x)
+	if lit == nil {
+		return nil
+	}
+	st, ok := v.p.TypesInfo.TypeOf(lit).Underlying().(*types.Struct)
+	if !ok {
+		return nil
+	}
+	field := st.Field(sel.Index()[0])
+	var value ast.Expr
+	for i, elt := range lit.Elts {
+		if kv, ok := elt.(*ast.KeyValueExpr); ok {
+			if key, ok := kv.Key.(*ast.Ident); ok && key.Name == field.Name() {
+				value = kv.Value
+			}
+		} else if i == sel.Index()[0] {
+			value = elt
+		}
+	}
+	if value == nil {
+		return nil
+	}
+	t := v.dynamicType(value)
+	if t == nil {
+		return nil
+	}
+	concrete, _, _ := types.LookupFieldOrMethod(t, true, method.Pkg(), method.Name())
+	return concrete
+}
+
+// resolveInterfaceMethod returns the concrete method that sel, the selection
+// of a method of an interface from x, calls once it's devirtualized, which is
+// only known if the concrete type of x's value is. Otherwise, it returns nil.
+func (v *inlinedDeclVisitor) resolveInterfaceMethod(x ast.Expr, sel *types.Selection) types.Object {
+	t := v.dynamicType(x)
+	if t == nil {
+		return nil
+	}
+	method := sel.Obj()
+	concrete, _, _ := types.LookupFieldOrMethod(t, true, method.Pkg(), method.Name())
+	return concrete
+}
+
+// structLit returns the composite literal that x, an expression of a struct
+// type, is, or that it points to, or that a local variable x is only assigned,
+// or nil if it's none of those.
+func (v *inlinedDeclVisitor) structLit(x ast.Expr) *ast.CompositeLit {
+	switch n := ast.Unparen(x).(type) {
+	case *ast.CompositeLit:
+		return n
+	case *ast.UnaryExpr:
+		if n.Op == token.AND {
+			return v.structLit(n.X)
+		}
+	case *ast.Ident:
+		if variable, ok := v.p.TypesInfo.Uses[n].(*types.Var); ok {
+			if value, ok := v.funcValues[variable]; ok {
+				return v.structLit(value)
+			}
+		}
+	}
+	return nil
+}
+
+// dynamicType returns the concrete type of the value of expr, if it's known
+// statically: if expr has a concrete type, or is the conversion of such an
+// expression to an interface, or a local variable that's only assigned one.
+// Otherwise, it returns nil.
+func (v *inlinedDeclVisitor) dynamicType(expr ast.Expr) types.Type {
+	expr = ast.Unparen(expr)
+	t := v.p.TypesInfo.TypeOf(expr)
+	if t == nil {
+		return nil
+	}
+	if !types.IsInterface(t) {
+		return t
+	}
+	switch n := expr.(type) {
+	case *ast.Ident:
+		if variable, ok := v.p.TypesInfo.Uses[n].(*types.Var); ok {
+			if value, ok := v.funcValues[variable]; ok {
+				return v.dynamicType(value)
+			}
+		}
+	case *ast.CallExpr:
+		if tv, ok := v.p.TypesInfo.Types[n.Fun]; ok && tv.IsType() && len(n.Args) == 1 {
+			return v.dynamicType(n.Args[0])
+		}
+	}
+	return nil
+}
+
+func (v *inlinedDeclVisitor) Visit(node ast.Node) ast.Visitor {
 	if node == nil {
 		return nil
 	}
@@ -378,29 +4007,201 @@ func (v *inlinedDeclVisitor) Visit(node ast.Node) ast.Visitor {
 	lineNumber := v.fileSet.Position(pos).Line
 
 	// Search for all func callsites of functions that were marked with
-	// gcassert:inline and add inline directives to those callsites.
+	// gcassert:inline or gcassert:noinline and add those callsites.
 	switch n := node.(type) {
+	case *ast.FuncDecl:
+		obj := v.p.TypesInfo.Defs[n.Name]
+		if v.inlineFuncs[obj] == inlinedeep && n.Body != nil {
+			v.nested[obj] = v.nestedCalls(n.Body)
+		}
+	case *ast.DeferStmt:
+		v.stmts[n.Call] = "defer"
+	case *ast.GoStmt:
+		v.stmts[n.Call] = "go"
 	case *ast.CallExpr:
 		callExpr := n
-		var obj types.Object
-		switch n := n.Fun.(type) {
-		case *ast.Ident:
-			obj = v.p.TypesInfo.Uses[n]
-		case *ast.SelectorExpr:
-			sel := v.p.TypesInfo.Selections[n]
-			if sel != nil {
-				obj = sel.Obj()
-			} else {
-				obj = v.p.TypesInfo.Uses[n.Sel]
+		objs, viaInterface := v.callees(n.Fun)
+		for _, obj := range objs {
+			directive, ok := v.inlineFuncs[obj]
+			if !ok {
+				continue
 			}
-		}
-		if _, ok := v.mustInlineFuncs[obj]; ok {
 			lineInfo := v.directiveMap[lineNumber]
 			lineInfo.n = node
-			lineInfo.inlinableCallsites = append(lineInfo.inlinableCallsites,
-				passInfo{colNo: v.fileSet.Position(callExpr.Lparen).Column})
+			cs := passInfo{
+				colNo:        v.fileSet.Position(callExpr.Lparen).Column,
+				callee:       obj.Name(),
+				call:         callExpr,
+				noinline:     directive == noinline,
+				viaInterface: viaInterface,
+				stmt:         v.stmts[callExpr],
+			}
+			v.called[obj] = true
+			if directive == inlinedeep {
+				cs.deep = obj
+			}
+			lineInfo.inlinableCallsites = append(lineInfo.inlinableCallsites, cs)
 			v.directiveMap[lineNumber] = lineInfo
 		}
 	}
 	return v
 }
+
+// nestedCalls returns the names of the functions with an inline or
+// inlinedeep directive that body calls.
+func (v *inlinedDeclVisitor) nestedCalls(body *ast.BlockStmt) []string {
+	var names []string
+	ast.Inspect(body, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		objs, _ := v.callees(call.Fun)
+		for _, obj := range objs {
+			if d, ok := v.inlineFuncs[obj]; ok && d != noinline && !slices.Contains(names, obj.Name()) {
+				names = append(names, obj.Name())
+			}
+		}
+		return true
+	})
+	return names
+}
+
+// callees returns the functions that fun, the function of a call, refers to,
+// and whether they're called through an interface. That's one function unless
+// fun is a method of a type parameter's constraint, which refers to a method
+// of each of the instantiations' type arguments. It returns nil if fun isn't
+// a function that can be resolved statically.
+func (v *inlinedDeclVisitor) callees(fun ast.Expr) ([]types.Object, bool) {
+	var obj types.Object
+	viaInterface := false
+	fun = ast.Unparen(fun)
+	// An explicitly instantiated generic function, such as f[int](x),
+	// is called through an index expression.
+	switch n := fun.(type) {
+	case *ast.IndexExpr:
+		fun = n.X
+	case *ast.IndexListExpr:
+		fun = n.X
+	}
+	switch n := fun.(type) {
+	case *ast.Ident:
+		obj = v.p.TypesInfo.Uses[n]
+		if variable, ok := obj.(*types.Var); ok {
+			// A local variable that's only assigned a function, or a
+			// method value, when it's declared is called like the function
+			// itself.
+			value, ok := v.funcValues[variable]
+			if !ok {
+				return nil, false
+			}
+			return v.callees(value)
+		}
+	case *ast.SelectorExpr:
+		sel := v.p.TypesInfo.Selections[n]
+		if sel != nil {
+			obj = sel.Obj()
+			if tp, ok := sel.Recv().(*types.TypeParam); ok {
+				return v.resolveConstraintMethod(tp, obj), false
+			} else if len(sel.Index()) > 1 {
+				obj = v.resolvePromotedMethod(n.X, sel)
+			} else if types.IsInterface(sel.Recv()) {
+				// A call through an interface reaches the method of the
+				// concrete type of its value once it's devirtualized.
+				obj = v.resolveInterfaceMethod(n.X, sel)
+				viaInterface = true
+			}
+		} else {
+			obj = v.p.TypesInfo.Uses[n.Sel]
+		}
+	}
+	if obj == nil {
+		return nil, false
+	}
+	// The methods of an instantiated generic type are distinct objects
+	// from the ones that were declared with the directive.
+	if fn, ok := obj.(*types.Func); ok {
+		obj = fn.Origin()
+	}
+	return []types.Object{obj}, viaInterface
+}
+
+// funcValues maps the local variables in file that are only assigned once,
+// when they're declared, to the value that they're assigned. The compiler
+// treats a call through such a variable as a call to its value, so it can
+// inline a function that's stored in one before it's called. A variable whose
+// fields or elements are assigned isn't included either, so that the value
+// of a struct variable's fields is known too.
+func funcValues(file *ast.File, info *types.Info) map[*types.Var]ast.Expr {
+	values := make(map[*types.Var]ast.Expr)
+	reassigned := make(map[*types.Var]bool)
+	ast.Inspect(file, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.AssignStmt:
+			for i, lhs := range n.Lhs {
+				ident, ok := ast.Unparen(lhs).(*ast.Ident)
+				if !ok {
+					if variable := rootVar(lhs, info); variable != nil {
+						reassigned[variable] = true
+					}
+					continue
+				}
+				if variable, ok := info.Defs[ident].(*types.Var); ok && len(n.Lhs) == len(n.Rhs) {
+					values[variable] = n.Rhs[i]
+				} else if variable, ok := info.Uses[ident].(*types.Var); ok {
+					reassigned[variable] = true
+				}
+			}
+		case *ast.SelectorExpr:
+			// Calling a method with a pointer receiver on a variable
+			// takes its address implicitly.
+			sel := info.Selections[n]
+			if sel == nil || sel.Kind() != types.MethodVal {
+				break
+			}
+			_, ptrRecv := sel.Obj().Type().(*types.Signature).Recv().Type().(*types.Pointer)
+			_, ptrX := sel.Recv().Underlying().(*types.Pointer)
+			if variable := rootVar(n.X, info); ptrRecv && !ptrX && variable != nil {
+				reassigned[variable] = true
+			}
+		case *ast.ValueSpec:
+			for i, name := range n.Names {
+				variable, ok := info.Defs[name].(*types.Var)
+				if ok && len(n.Names) == len(n.Values) && variable.Parent() != variable.Pkg().Scope() {
+					values[variable] = n.Values[i]
+				}
+			}
+		case *ast.UnaryExpr:
+			// A variable whose address is taken can be assigned through
+			// the pointer.
+			if ident, ok := ast.Unparen(n.X).(*ast.Ident); ok && n.Op == token.AND {
+				if variable, ok := info.Uses[ident].(*types.Var); ok {
+					reassigned[variable] = true
+				}
+			}
+		}
+		return true
+	})
+	for variable := range reassigned {
+		delete(values, variable)
+	}
+	return values
+}
+
+// rootVar returns the variable that expr, a variable or a field or element of
+// one, such as v.a[i].b, is part of, or nil if there's none.
+func rootVar(expr ast.Expr, info *types.Info) *types.Var {
+	for {
+		switch n := ast.Unparen(expr).(type) {
+		case *ast.Ident:
+			variable, _ := info.Uses[n].(*types.Var)
+			return variable
+		case *ast.SelectorExpr:
+			expr = n.X
+		case *ast.IndexExpr:
+			expr = n.X
+		default:
+			return nil
+		}
+	}
+}
@@ -2,13 +2,21 @@ package gcassert
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
 	"go/token"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/tools/go/analysis"
 	"golang.org/x/tools/go/packages"
 )
 
@@ -16,7 +24,7 @@ func TestParseDirectives(t *testing.T) {
 	fileSet := token.NewFileSet()
 	pkgs, err := packages.Load(&packages.Config{
 		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedCompiledGoFiles |
-			packages.NeedTypes | packages.NeedTypesInfo,
+			packages.NeedTypes | packages.NeedTypesInfo | packages.NeedTypesSizes,
 		Fset: fileSet,
 	}, "./testdata")
 	if err != nil {
@@ -26,18 +34,40 @@ func TestParseDirectives(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	var errOut bytes.Buffer
-	absMap, err := parseDirectives(pkgs, fileSet, cwd, &errOut)
+	r := &reporter{cwd: cwd, root: cwd, fileSet: fileSet}
+	absMap, _, err := parseDirectives(pkgs, fileSet, r, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	assert.Equal(t, `testdata/bad_directive.go:4:	//gcassert:foo
-func badDirective1()	{}: unknown directive "foo"
-testdata/bad_directive.go:8:	badDirective1(): unknown directive "bar"
-testdata/bad_directive.go:12:	//gcassert:inline,afterinline
-func badDirective3() {
-	badDirective2()
-}: unknown directive "afterinline"
+	var errOut bytes.Buffer
+	if err := writeFailures(&errOut, r.failures); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `testdata/bad_directive.go:3:	//gcassert:foo: unknown directive "foo"
+testdata/bad_directive.go:7:	//gcassert:bce,bar,inline: unknown directive "bar"
+testdata/bad_directive.go:11:	//gcassert:inline,afterinline: unknown directive "afterinline"
+testdata/bad_directive.go:17:	//gcassert:allocs:many,bce:1: directive "allocs:many" requires a number of allocations, such as allocs:2
+testdata/bad_directive.go:17:	//gcassert:allocs:many,bce:1: directive "bce" doesn't take an argument
+testdata/bad_directive.go:22:	//gcassert:register=2x: directive "register=2x" requires a variable name, such as register=sum
+testdata/bad_directive.go:27:	//gcassert:!bce: directive "bce" can't be negated
+testdata/bad_directive.go:32:	//gcassert:bce+x,inline+2: directive "bce+x" requires a positive number of lines, such as bce+3
+testdata/bad_directive.go:32:	//gcassert:bce+x,inline+2: directive "inline" can't be applied to a range of lines
+testdata/bad_directive.go:37:	//gcassert:ssa=cse: directive "ssa=cse" requires an SSA pass that reports its optimizations, one of phiopt, prove, such as ssa=prove
+testdata/constant.go:19:	len(s) * 2: expression is not a compile-time constant
+testdata/dispatch.go:21:	sum := ops.add(a, b): indirect call through function field cannot be inlined
+testdata/inline_callee.go:28:	//gcassert:inline=incr: directive "inline=incr" on a function can't name a callee
+testdata/inline_deep.go:39:	//gcassert:inlinedeep: directive must be attached to a function
+testdata/register.go:35:	sum := 0: no variable named sun is declared here
+testdata/spaced.go:19:	//gcassert:bce, fast: unknown directive "fast"
+testdata/unsupported.go:5:	//gcassert:hot: unsupported directive "hot": the Go toolchain doesn't split hot and cold functions into separate text sections
+testdata/unsupported.go:11:	//gcassert:hoist: unsupported directive "hoist": the Go compiler has no loop-invariant code motion pass, so it never hoists computations out of loops
+testdata/unsupported.go:17:	//gcassert:stackchan: unsupported directive "stackchan": the Go runtime allocates every channel on the heap with runtime.makechan, even one that doesn't escape; use noalloc to assert that no channel is made
+testdata/unsupported.go:26:	//gcassert:simd: unsupported directive "simd": the Go compiler doesn't auto-vectorize loops into SIMD instructions, on any architecture
+testdata/unsupported.go:35:	//gcassert:rodata: unsupported directive "rodata": the Go compiler places every package-level variable in writable data, even one that's never written; only the contents of constants, such as string literals, are read-only
+testdata/unsupported.go:43:	//gcassert:noresize: unsupported directive "noresize": the Go compiler doesn't prove that an append in a loop stays within the slice's capacity, and every map assignment may grow the map; use nogrow to assert that an append outside a loop doesn't grow its slice
+testdata/wordsize.go:24:	sliceHolder struct {
+	s []int
+}: type is 24 bytes, larger than the 8 byte machine word
 `, errOut.String())
 
 	// Convert the map into relative paths for ease of testing, and remove
@@ -46,6 +76,10 @@ func badDirective3() {
 	for absPath, m := range absMap {
 		for k, info := range m {
 			info.n = nil
+			for i := range info.inlinableCallsites {
+				info.inlinableCallsites[i].call = nil
+				info.inlinableCallsites[i].deep = nil
+			}
 			m[k] = info
 		}
 		relPath, err := filepath.Rel(cwd, absPath)
@@ -56,8 +90,14 @@ func badDirective3() {
 	}
 
 	expectedMap := directiveMap{
+		"testdata/allocs.go": {
+			12: {directives: []assertDirective{allocs}, args: map[int]string{0: "2"}},
+			21: {directives: []assertDirective{allocs}, args: map[int]string{0: "2"}},
+			30: {directives: []assertDirective{allocs}, args: map[int]string{0: "1"}},
+		},
 		"testdata/bad_directive.go": {
-			8: {directives: []assertDirective{bce, inline}},
+			8:  {directives: []assertDirective{bce, inline}},
+			18: {inlinableCallsites: []passInfo{{colNo: 15, callee: "badDirective3"}}},
 		},
 		"testdata/bce.go": {
 			8:  {directives: []assertDirective{bce}},
@@ -67,13 +107,161 @@ func badDirective3() {
 			19: {directives: []assertDirective{bce, inline}},
 			23: {directives: []assertDirective{bce}},
 		},
+		"testdata/bce_array.go": {
+			6:  {directives: []assertDirective{bce}},
+			8:  {directives: []assertDirective{bce}},
+			10: {directives: []assertDirective{bce}},
+			13: {directives: []assertDirective{bce}},
+			20: {directives: []assertDirective{bce}},
+			23: {directives: []assertDirective{bce}},
+		},
+		"testdata/bce_unsafe.go": {
+			13: {directives: []assertDirective{bce}},
+			17: {directives: []assertDirective{bce}},
+			20: {directives: []assertDirective{bce}},
+		},
+		"testdata/bce_merge.go": {
+			6:  {directives: []assertDirective{bcemerge}},
+			14: {directives: []assertDirective{bcemerge}},
+			21: {directives: []assertDirective{bcemerge}},
+		},
+		"testdata/callfree.go": {
+			17: {directives: []assertDirective{callfree}},
+			18: {inlinableCallsites: []passInfo{{colNo: 16, callfree: true}}},
+			28: {directives: []assertDirective{callfree}},
+			29: {inlinableCallsites: []passInfo{{colNo: 14, callfree: true}}},
+			38: {directives: []assertDirective{callfree}},
+		},
+		"testdata/bce_span.go": {
+			11: {directives: []assertDirective{bce}},
+			12: {directives: []assertDirective{bce}},
+			13: {directives: []assertDirective{bce}},
+			22: {directives: []assertDirective{bce}},
+			23: {directives: []assertDirective{bce}},
+			24: {directives: []assertDirective{bce}},
+			25: {directives: []assertDirective{bce}},
+		},
+		"testdata/constant.go": {
+			14: {directives: []assertDirective{constant}},
+			19: {directives: []assertDirective{constant}},
+		},
+		"testdata/constfold.go": {
+			9:  {directives: []assertDirective{constfold}},
+			14: {directives: []assertDirective{constfold}},
+		},
+		"testdata/devirt.go": {
+			19: {directives: []assertDirective{devirt}},
+			27: {directives: []assertDirective{devirt}},
+		},
+		"testdata/devirtualize.go": {
+			24: {inlinableCallsites: []passInfo{{colNo: 25, callee: "scale", viaInterface: true}}},
+			33: {inlinableCallsites: []passInfo{{colNo: 16, callee: "scale", viaInterface: true}}},
+			41: {directives: []assertDirective{inline}, interfaceCall: true},
+			60: {inlinableCallsites: []passInfo{{colNo: 36, callee: "scale", noinline: true, viaInterface: true}}},
+		},
+		"testdata/dispatch.go": {
+			23: {directives: []assertDirective{inline}},
+			26: {directives: []assertDirective{inline}, args: map[int]string{0: "addInts"}},
+		},
+		"testdata/generic.go": {
+			26: {inlinableCallsites: []passInfo{{colNo: 12, callee: "add"}, {colNo: 12, callee: "add"}}},
+		},
+		"testdata/generic_inline.go": {
+			25: {directives: []assertDirective{bce}},
+			29: {directives: []assertDirective{bce}},
+			35: {inlinableCallsites: []passInfo{{colNo: 12, callee: "maxOf"}, {colNo: 31, callee: "maxOf"}}},
+			36: {inlinableCallsites: []passInfo{{colNo: 21, callee: "maxOf"}}},
+			37: {inlinableCallsites: []passInfo{{colNo: 33, callee: "first"}}},
+			38: {inlinableCallsites: []passInfo{{colNo: 34, callee: "first"}}},
+		},
 		"testdata/inline.go": {
-			46: {inlinableCallsites: []passInfo{{colNo: 15}}},
+			46: {inlinableCallsites: []passInfo{{colNo: 15, callee: "alwaysInlined"}}},
 			50: {directives: []assertDirective{inline}},
 			52: {directives: []assertDirective{inline}},
 			56: {directives: []assertDirective{inline}},
-			58: {inlinableCallsites: []passInfo{{colNo: 36}}},
-			59: {inlinableCallsites: []passInfo{{colNo: 35}}},
+			58: {inlinableCallsites: []passInfo{{colNo: 36, callee: "alwaysInlinedMethod"}}},
+			59: {inlinableCallsites: []passInfo{{colNo: 35, callee: "neverInlinedMethod"}}},
+		},
+		"testdata/inline_bce.go": {
+			14: {directives: []assertDirective{inlinebce}, inlinableCallsites: []passInfo{{colNo: 18, callee: "thirdByte"}}},
+			20: {directives: []assertDirective{inlinebce}, inlinableCallsites: []passInfo{{colNo: 18, callee: "thirdByte"}}},
+		},
+		"testdata/inline_callee.go": {
+			14: {directives: []assertDirective{inline}, args: map[int]string{0: "incr"}},
+			17: {directives: []assertDirective{inline}, args: map[int]string{0: "slowIncr"}},
+			20: {directives: []assertDirective{inline}},
+			22: {
+				directives:         []assertDirective{inline},
+				inlinableCallsites: []passInfo{{colNo: 15, callee: "plus"}},
+				args:               map[int]string{0: "plus"},
+			},
+		},
+		"testdata/inline_deep.go": {
+			18: {inlinableCallsites: []passInfo{{colNo: 17, callee: "deepIncr"}, {colNo: 26, callee: "deepIncr"}}},
+			23: {inlinableCallsites: []passInfo{{colNo: 19, callee: "deepTimes3"}}},
+			29: {inlinableCallsites: []passInfo{{colNo: 17, callee: "deepAddTwo", nested: []string{"deepIncr"}}}},
+			32: {inlinableCallsites: []passInfo{{colNo: 22, callee: "deepTimes3Plus1", nested: []string{"deepTimes3"}}}},
+			40: {inlinableCallsites: []passInfo{{colNo: 19, callee: "deepAddTwo", nested: []string{"deepIncr"}}}},
+		},
+		"testdata/inline_defer.go": {
+			19: {inlinableCallsites: []passInfo{{colNo: 17, callee: "deferIncr", stmt: "defer"}}},
+			20: {inlinableCallsites: []passInfo{{colNo: 14, callee: "deferIncr", stmt: "go"}}},
+			22: {inlinableCallsites: []passInfo{{colNo: 17, callee: "deferDecr", stmt: "defer"}}},
+			23: {inlinableCallsites: []passInfo{{colNo: 14, callee: "deferDecr", stmt: "go"}}},
+		},
+		"testdata/inline_cost.go": {
+			6:  {directives: []assertDirective{cost}, args: map[int]string{0: "70"}},
+			14: {directives: []assertDirective{cost}, args: map[int]string{0: "5"}},
+			26: {directives: []assertDirective{cost}, args: map[int]string{0: "80"}},
+			45: {inlinableCallsites: []passInfo{{colNo: 17, callee: "cheapAdd"}}},
+		},
+		"testdata/inline_line.go": {
+			15: {inlinableCallsites: []passInfo{{colNo: 14, callee: "lineAdd"}, {colNo: 14, callee: "lineSub"}}},
+		},
+		"testdata/inline_noalloc.go": {
+			16: {directives: []assertDirective{inlinenoalloc}},
+			26: {directives: []assertDirective{inlinenoalloc}},
+		},
+		"testdata/inline_value.go": {
+			20: {inlinableCallsites: []passInfo{{colNo: 8, callee: "doubled"}, {colNo: 15, callee: "doubled"}, {colNo: 30, callee: "doubled"}}},
+			24: {inlinableCallsites: []passInfo{{colNo: 8, callee: "plus"}}},
+			35: {inlinableCallsites: []passInfo{{colNo: 16, callee: "doubled"}, {colNo: 23, callee: "plus"}}},
+		},
+		"testdata/inlineeq.go": {
+			14: {directives: []assertDirective{inlineeq}},
+			17: {directives: []assertDirective{inlineeq}},
+		},
+		"testdata/mapfaststr.go": {
+			7:  {directives: []assertDirective{mapfaststr}},
+			13: {directives: []assertDirective{mapfaststr}},
+		},
+		"testdata/maxtextsize.go": {
+			7:  {directives: []assertDirective{maxtextsize}, args: map[int]string{0: "64"}},
+			15: {directives: []assertDirective{maxtextsize}, args: map[int]string{0: "64"}},
+		},
+		"testdata/nilcheck.go": {
+			11: {directives: []assertDirective{nilcheck}},
+			19: {directives: []assertDirective{nilcheck}},
+			27: {directives: []assertDirective{nilcheck}},
+		},
+		"testdata/noalloc.go": {
+			10: {directives: []assertDirective{noalloc}},
+			32: {directives: []assertDirective{noalloc}},
+			43: {directives: []assertDirective{noalloc}},
+		},
+		"testdata/noalloc_encoder.go": {
+			17: {directives: []assertDirective{noalloc}},
+			42: {directives: []assertDirective{noalloc}},
+		},
+		"testdata/noalloc_line.go": {
+			8:  {directives: []assertDirective{noalloc}},
+			13: {directives: []assertDirective{noalloc}},
+			19: {directives: []assertDirective{noalloc}},
+			28: {directives: []assertDirective{noescape, noalloc}},
+		},
+		"testdata/noalloc_pool.go": {
+			15: {directives: []assertDirective{noalloc}},
+			29: {directives: []assertDirective{noalloc}},
 		},
 		"testdata/noescape.go": {
 			13: {directives: []assertDirective{noescape}},
@@ -83,9 +271,109 @@ func badDirective3() {
 			38: {directives: []assertDirective{noescape}},
 			49: {directives: []assertDirective{noescape}},
 			57: {directives: []assertDirective{noescape}},
+			68: {directives: []assertDirective{noescape}},
+			81: {directives: []assertDirective{noescape}},
 		},
 		"testdata/issue5.go": {
-			4: {inlinableCallsites: []passInfo{{colNo: 14}}},
+			4: {inlinableCallsites: []passInfo{{colNo: 14, callee: "Layout"}}},
+		},
+		"testdata/nocopy.go": {
+			13: {directives: []assertDirective{nocopy}},
+			20: {directives: []assertDirective{nocopy}},
+		},
+		"testdata/noescape_call.go": {
+			16: {directives: []assertDirective{noescapecall}},
+			25: {directives: []assertDirective{noescapecall}},
+		},
+		"testdata/noescape_closure.go": {
+			18: {directives: []assertDirective{noescapeclosure}},
+			20: {directives: []assertDirective{noescapeclosure}},
+		},
+		"testdata/nogrow.go": {
+			7:  {directives: []assertDirective{nogrow}},
+			15: {directives: []assertDirective{nogrow}},
+			22: {directives: []assertDirective{nogrow}},
+			28: {directives: []assertDirective{nogrow}},
+		},
+		"testdata/noinline.go": {
+			21: {inlinableCallsites: []passInfo{{colNo: 17, callee: "profiled", noinline: true}}},
+			22: {inlinableCallsites: []passInfo{{colNo: 25, callee: "profiledBoundary", noinline: true}}},
+			24: {directives: []assertDirective{noinline}},
+			27: {directives: []assertDirective{noinline}},
+			29: {directives: []assertDirective{noinline}},
+		},
+		"testdata/stack.go": {
+			10: {directives: []assertDirective{stack}},
+			12: {directives: []assertDirective{stack}},
+			15: {directives: []assertDirective{stack}},
+			19: {directives: []assertDirective{stack}},
+		},
+		"testdata/spaced.go": {
+			12: {directives: []assertDirective{bce}},
+			13: {directives: []assertDirective{inline, bce}},
+			16: {directives: []assertDirective{bce, inline}},
+			19: {directives: []assertDirective{bce}},
+		},
+		"testdata/ssa.go": {
+			6:  {directives: []assertDirective{ssa}, args: map[int]string{0: "prove"}},
+			16: {directives: []assertDirective{ssa}, args: map[int]string{0: "phiopt"}},
+			21: {directives: []assertDirective{ssa}, args: map[int]string{0: "prove"}},
+		},
+		"testdata/staticitab.go": {
+			15: {directives: []assertDirective{staticitab}},
+			21: {directives: []assertDirective{staticitab}},
+		},
+		"testdata/typeassert_merge.go": {
+			17: {directives: []assertDirective{typeassertmerge}},
+			27: {directives: []assertDirective{typeassertmerge}},
+		},
+		"testdata/wordsize.go": {
+			8:  {directives: []assertDirective{wordsize}},
+			15: {directives: []assertDirective{wordsize}},
+			24: {directives: []assertDirective{wordsize}},
+		},
+		"testdata/nomorestack.go": {
+			7:  {directives: []assertDirective{nomorestack}},
+			20: {directives: []assertDirective{nomorestack}},
+		},
+		"testdata/noretspill.go": {
+			23: {directives: []assertDirective{noretspill}},
+			30: {directives: []assertDirective{noretspill}},
+			37: {directives: []assertDirective{noretspill}},
+		},
+		"testdata/noselectgo.go": {
+			8:  {directives: []assertDirective{noselectgo}},
+			21: {directives: []assertDirective{noselectgo}},
+		},
+		"testdata/nospill.go": {
+			15: {directives: []assertDirective{nospill}},
+			21: {directives: []assertDirective{nospill}},
+		},
+		"testdata/opendefer.go": {
+			15: {directives: []assertDirective{opendefer}},
+			24: {directives: []assertDirective{opendefer}},
+		},
+		"testdata/promoted.go": {
+			22: {inlinableCallsites: []passInfo{{colNo: 32, callee: "increment"}}},
+			27: {inlinableCallsites: []passInfo{{colNo: 50, callee: "increment"}}},
+			47: {inlinableCallsites: []passInfo{{colNo: 20, callee: "increment"}}},
+			51: {inlinableCallsites: []passInfo{{colNo: 63, callee: "increment", noinline: true}}},
+		},
+		"testdata/range_int.go": {
+			8:  {directives: []assertDirective{bce}},
+			11: {directives: []assertDirective{bce}},
+			20: {directives: []assertDirective{bce}},
+			23: {directives: []assertDirective{inline}},
+		},
+		"testdata/register.go": {
+			11: {directives: []assertDirective{register}, args: map[int]string{0: "sum"}},
+			22: {directives: []assertDirective{register}, args: map[int]string{0: "total"}},
+			35: {directives: []assertDirective{register}, args: map[int]string{0: "sun"}},
+		},
+		"testdata/staticinit.go": {
+			8:  {directives: []assertDirective{staticinit}},
+			14: {directives: []assertDirective{staticinit}},
+			20: {directives: []assertDirective{staticinit}},
 		},
 	}
 	assert.Equal(t, expectedMap, relMap)
@@ -96,23 +384,51 @@ func TestGCAssert(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	expectedOutput := `testdata/bad_directive.go:4:	//gcassert:foo
-func badDirective1()	{}: unknown directive "foo"
-testdata/bad_directive.go:8:	badDirective1(): unknown directive "bar"
-testdata/bad_directive.go:12:	//gcassert:inline,afterinline
-func badDirective3() {
-	badDirective2()
-}: unknown directive "afterinline"
-testdata/noescape.go:13:	foo := foo{a: 1, b: 2}: foo escapes to heap:
-testdata/noescape.go:27:	// This annotation should fail, because f will escape to the heap.
+	expectedOutput := `testdata/bad_directive.go:3:	//gcassert:foo: unknown directive "foo"
+testdata/bad_directive.go:7:	//gcassert:bce,bar,inline: unknown directive "bar"
+testdata/bad_directive.go:11:	//gcassert:inline,afterinline: unknown directive "afterinline"
+testdata/bad_directive.go:17:	//gcassert:allocs:many,bce:1: directive "allocs:many" requires a number of allocations, such as allocs:2
+testdata/bad_directive.go:17:	//gcassert:allocs:many,bce:1: directive "bce" doesn't take an argument
+testdata/bad_directive.go:22:	//gcassert:register=2x: directive "register=2x" requires a variable name, such as register=sum
+testdata/bad_directive.go:27:	//gcassert:!bce: directive "bce" can't be negated
+testdata/bad_directive.go:32:	//gcassert:bce+x,inline+2: directive "bce+x" requires a positive number of lines, such as bce+3
+testdata/bad_directive.go:32:	//gcassert:bce+x,inline+2: directive "inline" can't be applied to a range of lines
+testdata/bad_directive.go:37:	//gcassert:ssa=cse: directive "ssa=cse" requires an SSA pass that reports its optimizations, one of phiopt, prove, such as ssa=prove
+testdata/constant.go:19:	len(s) * 2: expression is not a compile-time constant
+testdata/dispatch.go:21:	sum := ops.add(a, b): indirect call through function field cannot be inlined
+testdata/inline_callee.go:28:	//gcassert:inline=incr: directive "inline=incr" on a function can't name a callee
+testdata/inline_deep.go:39:	//gcassert:inlinedeep: directive must be attached to a function
+testdata/register.go:35:	sum := 0: no variable named sun is declared here
+testdata/spaced.go:19:	//gcassert:bce, fast: unknown directive "fast"
+testdata/unsupported.go:5:	//gcassert:hot: unsupported directive "hot": the Go toolchain doesn't split hot and cold functions into separate text sections
+testdata/unsupported.go:11:	//gcassert:hoist: unsupported directive "hoist": the Go compiler has no loop-invariant code motion pass, so it never hoists computations out of loops
+testdata/unsupported.go:17:	//gcassert:stackchan: unsupported directive "stackchan": the Go runtime allocates every channel on the heap with runtime.makechan, even one that doesn't escape; use noalloc to assert that no channel is made
+testdata/unsupported.go:26:	//gcassert:simd: unsupported directive "simd": the Go compiler doesn't auto-vectorize loops into SIMD instructions, on any architecture
+testdata/unsupported.go:35:	//gcassert:rodata: unsupported directive "rodata": the Go compiler places every package-level variable in writable data, even one that's never written; only the contents of constants, such as string literals, are read-only
+testdata/unsupported.go:43:	//gcassert:noresize: unsupported directive "noresize": the Go compiler doesn't prove that an append in a loop stays within the slice's capacity, and every map assignment may grow the map; use nogrow to assert that an append outside a loop doesn't grow its slice
+testdata/wordsize.go:24:	sliceHolder struct {
+	s []int
+}: type is 24 bytes, larger than the 8 byte machine word
+testdata/noalloc_line.go:28:2:	x := 1: x escapes to heap: x (8 bytes)
+testdata/noescape.go:13:2:	foo := foo{a: 1, b: 2}: foo escapes to heap: foo (16 bytes)
+testdata/noescape.go:27:7:	// This annotation should fail, because f will escape to the heap.
 //
 //gcassert:noescape
 func (f foo) setA(a int) *foo {
 	f.a = a
 	return &f
-}: f escapes to heap:
-testdata/noescape.go:38:	: a escapes to heap:
-testdata/noescape.go:49:	// This annotation should fail, because the parameter f is leaked.
+}: f escapes to heap: f (16 bytes)
+testdata/noescape.go:38:2:	// This annotation should fail, because the parameter a escapes, unlike f.
+//
+//gcassert:noescape
+func (f foo) returnA(
+
+	a int,
+	b int,
+) *int {
+	return &a
+}: a escapes to heap: a (8 bytes)
+testdata/noescape.go:49:7:	// This annotation should fail, because the parameter f is leaked.
 // Specifically this means that if you call this method where f was a value
 // (not a pointer) then this will cause a heap allocation.
 //
@@ -120,17 +436,235 @@ testdata/noescape.go:49:	// This annotation should fail, because the parameter f
 func (f *foo) printReceiver() {
 	fmt.Printf("#v", f)
 }: leaking param: f
-testdata/bce.go:8:	fmt.Println(ints[5]): Found IsInBounds
-testdata/bce.go:23:	fmt.Println(ints[1:7]): Found IsSliceInBounds
+testdata/noescape.go:70:2:	// This annotation should fail, because the parameters p and n escape to the
+// heap, even though they're declared on lines of their own. It doesn't fail
+// because local escapes, since it isn't a parameter.
+//
+//gcassert:noescape
+func leakParams(
+	p *foo,
+	n int,
+) (*int, *foo) {
+	local := foo{a: n}
+	leakedFoo = p
+	return &n, &local
+}: n escapes to heap: n (8 bytes)
+testdata/noescape.go:69:2:	// This annotation should fail, because the parameters p and n escape to the
+// heap, even though they're declared on lines of their own. It doesn't fail
+// because local escapes, since it isn't a parameter.
+//
+//gcassert:noescape
+func leakParams(
+	p *foo,
+	n int,
+) (*int, *foo) {
+	local := foo{a: n}
+	leakedFoo = p
+	return &n, &local
+}: leaking param: p
+testdata/noescape_call.go:25:	p := h.field(): h escapes to heap:
+testdata/noescape_closure.go:20:	storeCallback(func() { x++ }): func literal escapes to heap:
+testdata/stack.go:15:2:	r := stackPair{b: n}: moved to heap: r
+testdata/stack.go:12:7:	q := &stackPair{a: n}: &stackPair{...} escapes to heap
+testdata/bce.go:8:18:	fmt.Println(ints[5]): Found IsInBounds
+testdata/bce.go:23:18:	fmt.Println(ints[1:7]): Found IsSliceInBounds
+testdata/bce_unsafe.go:20:14:	sum += words[0]: Found IsInBounds
+testdata/bce_span.go:12:11:	b := ints[1]: Found IsInBounds
+testdata/bce_span.go:13:11:	c := ints[2]: Found IsInBounds
+testdata/bce_array.go:23:12:	sum += arr[i]: Found IsInBounds
+testdata/bce_array.go:13:12:	sum += arr[i]: Found IsInBounds
+testdata/nilcheck.go:19:9:	s += p[i]: generated nil check
+testdata/range_int.go:20:14:	sum += ints[i]: Found IsInBounds
+testdata/generic_inline.go:29:11:	return xs[0]: Found IsInBounds
+testdata/allocs.go:21:	// This assertion should fail, because the function allocates three times.
+//
+//gcassert:allocs:2
+func allocThrice(n int) {
+	var p allocPair
+	allocSink = append(allocSink, &p, &allocPair{a: n}, &allocPair{b: n})
+}: expected 2 allocations, found 3: moved to heap: p; &allocPair{...} escapes to heap; &allocPair{...} escapes to heap
 testdata/bce.go:17:	sum += notInlinable(ints[i]): call was not inlined
 testdata/bce.go:19:	sum += notInlinable(ints[i]): call was not inlined
-testdata/inline.go:46:	alwaysInlined(3): call was not inlined
+testdata/bce_merge.go:14:	// This assertion should fail, because b[0] and b[1] are checked separately.
+//
+//gcassert:bcemerge
+func littleEndian16Unmerged(b []byte) uint16 {
+	return uint16(b[0]) | uint16(b[1])<<8
+}: found 2 bounds checks, expected at most one
+testdata/bce_merge.go:21:	return uint16(b[i]) | uint16(b[i+1])<<8: found 2 bounds checks, expected at most one
+testdata/callfree.go:29:14:	cube(x): call in loop was not inlined
+testdata/callfree.go:38:	for _, w := range words {
+	counts[w]++
+}: loop calls the runtime: CALL runtime.mapassign_faststr(SB)
+testdata/constfold.go:14:	return sumOfSquares(x, 4): inlined call wasn't folded to a constant: IMULQ AX, AX
+testdata/devirt.go:27:	total += s.area(): interface call was not devirtualized
+testdata/devirtualize.go:41:	sum += s.scale(): interface call was not devirtualized, so it was not inlined
+testdata/generic.go:26:12:	x.add(s): call was not inlined
+testdata/inline.go:46:15:	alwaysInlined(3): call was not inlined
 testdata/inline.go:52:	sum += notInlinable(i): call was not inlined
 testdata/inline.go:56:	sum += 1: call was not inlined
-testdata/inline.go:59:	test(0).neverInlinedMethod(10): call was not inlined
-testdata/inline.go:61:	otherpkg.A{}.NeverInlined(sum): call was not inlined
-testdata/inline.go:63:	otherpkg.NeverInlinedFunc(sum): call was not inlined
-testdata/issue5.go:4:	Gen().Layout(): call was not inlined
+testdata/inline.go:59:35:	test(0).neverInlinedMethod(10): call was not inlined
+testdata/inline.go:61:27:	otherpkg.A{}.NeverInlined(sum): call was not inlined
+testdata/inline.go:63:27:	otherpkg.NeverInlinedFunc(sum): call was not inlined
+testdata/inline_bce.go:20:	thirdByte(b): inlined call is bounds checked: CALL runtime.panicIndex(SB)
+testdata/inline_callee.go:17:	y := slowIncr(incr(i)): call to slowIncr was not inlined
+testdata/inline_cost.go:14:	// This assertion should fail, because the function can be inlined, but the
+// loop costs more than the bound.
+//
+//gcassert:inline,cost<=5
+func pricierSum(ints []int) int {
+	total := 0
+	for i := range ints {
+		total += ints[i] * ints[i]
+	}
+	return total
+}: inline cost 21 exceeds the limit of 5
+testdata/inline_cost.go:26:	// This assertion should fail, because the function costs more than the
+// compiler's inlining budget, so it can't be inlined at all.
+//
+//gcassert:cost<=80
+func tooComplex(ints []int) int {
+	total := 0
+	for i := range ints {
+		for j := range ints {
+			total += ints[i] * ints[j]
+			total ^= ints[j] >> 3
+			total += ints[i] % (ints[j] | 1)
+			total -= ints[i] / (ints[j] | 1)
+		}
+	}
+	for i := range ints {
+		total += ints[i] * ints[i]
+		total ^= ints[i] >> 3
+		total += ints[i] % (ints[i] | 1)
+	}
+	return total
+}: cannot inline tooComplex: function too complex: cost 87 exceeds budget 80
+testdata/inline_deep.go:23:19:	deepTimes3(x): call was not inlined
+testdata/inline_deep.go:32:22:	deepTimes3Plus1(x): call to deepTimes3 in deepTimes3Plus1 was not inlined
+testdata/inline_defer.go:22:17:	deferDecr(x): call in defer statement was not inlined into its wrapper
+testdata/inline_defer.go:23:14:	deferDecr(x): call in go statement was not inlined into its wrapper
+testdata/inline_line.go:15:14:	lineSub(x): call was not inlined
+testdata/inline_noalloc.go:26:	s := newScratch(): allocation remains after inlining: CALL runtime.newobject(SB)
+testdata/inline_value.go:24:8:	p(4): call was not inlined
+testdata/inline_value.go:35:23:	p(2): call was not inlined
+testdata/inlineeq.go:17:	large := *p == *q: comparison calls a runtime helper: CALL runtime.memequal(SB)
+testdata/issue5.go:4:14:	Gen().Layout(): call was not inlined
+testdata/mapfaststr.go:13:	return values[key][0]: map lookup doesn't use the faststr helper: CALL runtime.mapaccess1(SB)
+testdata/maxtextsize.go:15:	// This assertion should fail, because the bounds checks and arithmetic in the
+// loop take far more than the limit.
+//
+//gcassert:maxtextsize:64
+func largeText(ints []int) int {
+	sum := 0
+	for i := 0; i+8 <= len(ints); i += 8 {
+		sum += ints[i] * ints[i+1]
+		sum += ints[i+2] * ints[i+3]
+		sum += ints[i+4] * ints[i+5]
+		sum += ints[i+6] * ints[i+7]
+	}
+	return sum
+}: function is 306 bytes, larger than the limit of 64
+testdata/nilcheck.go:27:	return n.val: no nil check was removed
+testdata/noalloc.go:32:	for k, v := range m {
+	noallocSink = append(noallocSink, namedValue{name: k, value: v})
+}: unexpected allocation: namedValue{...} escapes to heap
+testdata/noalloc.go:43:	for _, v := range m {
+	c := make(chan int, 1)
+	c <- v
+	sum += <-c
+}: unexpected allocation: channel allocated by runtime.makechan
+testdata/noalloc_encoder.go:42:	// This assertion should fail, because the error allocated by newEncodeError is
+// made by this function once newEncodeError is inlined.
+//
+//gcassert:noalloc
+func encodeRecordChecked(dst []byte, r record) []byte {
+	if len(r.name) > 11 {
+		lastEncodeError = newEncodeError(r.name)
+		return dst
+	}
+	dst = append(dst, r.kind)
+	dst = binary.LittleEndian.AppendUint32(dst, r.id)
+	return append(dst, r.name...)
+}: unexpected allocation: &encodeError{...} escapes to heap
+testdata/noalloc_line.go:8:	lineSink = v: unexpected allocation: v escapes to heap
+testdata/noalloc_line.go:13:	return func() int { return x }: unexpected allocation: func literal escapes to heap
+testdata/noalloc_pool.go:29:	// This assertion should fail, because putting a struct value into the pool
+// boxes it in a new heap allocation.
+//
+//gcassert:noalloc
+func usePooledBufferValue() byte {
+	buf := pooledBufferValues.Get().(pooledBuffer)
+	buf.b[0] = 1
+	x := buf.b[0]
+	pooledBufferValues.Put(buf)
+	return x
+}: unexpected allocation: buf escapes to heap
+testdata/nocopy.go:20:	globalBigStruct = *p: struct copy was not elided: DUFFCOPY $448
+testdata/nogrow.go:15:	buf = append(buf[:0], version, flags, kind): unexpected allocation: append may grow the slice
+testdata/nogrow.go:22:	buf = append(buf[:0], data...): unexpected allocation: append may grow the slice
+testdata/nogrow.go:28:	m[k] = 1: unexpected allocation: map assignment may grow the map
+testdata/noinline.go:21:17:	profiled(1): function was inlined, losing profiling boundary
+testdata/noinline.go:24:	sum += inlinable(3): function was inlined, losing profiling boundary
+testdata/noinline.go:29:	sum += inlinable(5): function was inlined, losing profiling boundary
+testdata/nomorestack.go:20:	// This assertion should fail, because the buffer gives the function a large
+// frame, and it calls another function.
+//
+//gcassert:nomorestack
+func largeFrame() byte {
+	var buf [1024]byte
+	fillFrame(&buf)
+	return buf[0]
+}: function may grow its stack, with a frame of 1040 bytes: CALL runtime.morestack_noctxt(SB)
+testdata/noretspill.go:30:	q := makeSpillQuad(a): return value passed on the stack: MOVUPS (SP), X0
+testdata/noretspill.go:37:	p := makeSpillPoint(a): return value spilled to the stack: MOVQ AX, github.com/fmstephe/gcassert/testdata..autotmp_5+16(SP)
+testdata/noselectgo.go:21:	select {
+case v := <-a:
+	return v
+case v := <-b:
+	return v
+}: select was not specialized: CALL runtime.selectgo(SB)
+testdata/nospill.go:21:	return twelveArgs(x, x+1, x+2, x+3, x+4, x+5, x+6, x+7, x+8, x+9, x+10, x+11): value spilled to the stack: MOVQ DX, (SP)
+testdata/opendefer.go:24:	defer c.Close(): defer was not open-coded
+testdata/promoted.go:27:50:	embeddingCounter{counter{n: 2}}.increment(): call was not inlined
+testdata/promoted.go:47:20:	e.increment(): call was not inlined
+testdata/register.go:22:	// This assertion should fail, because total is live across a call, which may
+// clobber every register.
+//
+//gcassert:register=total
+func sumAcrossCalls(xs []int) int {
+	total := 0
+	for _, x := range xs {
+		total += x
+		registerBarrier()
+	}
+	return total
+}: total spilled to the stack: MOVQ DX, github.com/fmstephe/gcassert/testdata.total+8(SP)
+testdata/spaced.go:16:	c := ints[1]: call was not inlined
+testdata/ssa.go:21:	return xs[i]: ssa pass prove reported no optimization
+testdata/stack.go:19:	x := 3: no allocation found to prove is on the stack
+testdata/staticinit.go:14:	// This assertion should fail, because strings.ToUpper must be called by the
+// package's init function.
+//
+//gcassert:staticinit
+var dynamicString = strings.ToUpper("gcassert"): global requires runtime initialization
+testdata/staticinit.go:20:	// This assertion should fail, because the call on the second line of
+// the initializer must be made by the package's init function.
+//gcassert:staticinit
+dynamicTable = []string{
+	"gcassert",
+	strings.ToLower("GCASSERT"),
+}: global requires runtime initialization
+testdata/staticitab.go:21:	readerSink = rc: itab is looked up at run time: CALL runtime.typeAssert(SB)
+testdata/typeassert_merge.go:27:	// This assertion should fail, because each assertion to an interface type
+// looks up the method table again.
+//
+//gcassert:typeassertmerge
+func callAssertedTwice(i any) {
+	i.(assertedIface).assertedMethod()
+	i.(assertedIface).assertedMethod()
+}: found 2 type assertion checks, expected at most one
+gcassert: 191 directives checked, 90 failed (24 inline, 9 bce, 6 noalloc, 6 noescape, 3 nogrow, 3 noinline, 3 stack, 2 bcemerge, 2 callfree, 2 cost, 2 inlinedeep, 2 nilcheck, 2 noretspill, 2 register, 2 staticinit, 1 allocs, 1 const, 1 constfold, 1 devirt, 1 inlinebce, 1 inlineeq, 1 inlinenoalloc, 1 mapfaststr, 1 maxtextsize, 1 nocopy, 1 noescapecall, 1 noescapeclosure, 1 nomorestack, 1 noselectgo, 1 nospill, 1 opendefer, 1 ssa, 1 staticitab, 1 typeassertmerge, 1 wordsize), 16 malformed
 `
 
 	testCases := []struct {
@@ -174,10 +708,705 @@ testdata/issue5.go:4:	Gen().Layout(): call was not inlined
 			} else {
 				err = GCAssertCwd(&w, testCase.cwd, testCase.pkgs...)
 			}
-			if err != nil {
-				t.Fatal(err)
+			assert.Equal(t, ErrAssertionsFailed, err)
+			assert.Equal(t, testCase.expected, withoutLog(w.String()))
+		})
+	}
+}
+
+func TestGCAssertRace(t *testing.T) {
+	var w strings.Builder
+	err := GCAssertWithOptions(&w, Options{Race: true}, "./testdata/race")
+	assert.Equal(t, ErrAssertionsFailed, err)
+	assert.Equal(t, `testdata/race/race.go:17:13:	return ints[0]: Found IsInBounds
+gcassert: 3 directives checked, 1 failed (1 bce)
+`, withoutLog(w.String()))
+}
+
+func TestGCAssertToolchains(t *testing.T) {
+	// Use the toolchain that is running the test, so that nothing needs to
+	// be downloaded.
+	toolchain := runtime.Version()
+	var w strings.Builder
+	err := GCAssertWithOptions(&w, Options{Toolchains: []string{toolchain}}, "./testdata/toolchain")
+	assert.Equal(t, ErrAssertionsFailed, err)
+	assert.Equal(t, toolchain+`: testdata/toolchain/toolchain.go:6:13:	return ints[0]: Found IsInBounds
+`+toolchain+`: gcassert: 1 directive checked, 1 failed (1 bce)
+`, withoutLog(w.String()))
+}
+
+func TestGCAssertSource(t *testing.T) {
+	src := `package source
+
+func first(ints []int) int {
+	return ints[0] //gcassert:bce
+}
+
+func sum(ints []int) int {
+	s := 0
+	for i := range ints {
+		s += ints[i] //gcassert:bce
+	}
+	return s
+}
+`
+	failures, err := GCAssertSource(src, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []Failure{{
+		File:        "source.go",
+		Line:        4,
+		Col:         13,
+		Directive:   "bce",
+		Message:     "Found IsInBounds",
+		Source:      "return ints[0]",
+		compilerPos: true,
+		nodeLine:    4,
+		nodeCol:     2,
+	}}, failures)
+}
+
+func TestGCAssertTests(t *testing.T) {
+	var w strings.Builder
+	err := GCAssertWithOptions(&w, Options{}, "./testdata/tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "gcassert: 1 directive checked, 0 failed\n", withoutLog(w.String()))
+
+	w.Reset()
+	err = GCAssertWithOptions(&w, Options{Tests: true}, "./testdata/tests")
+	assert.Equal(t, ErrAssertionsFailed, err)
+	assert.Equal(t, "testdata/tests/sum_test.go:12:15:\tsink += ints[i]: Found IsInBounds\ngcassert: 3 directives checked, 1 failed (1 bce)\n", withoutLog(w.String()))
+}
+
+func TestGCAssertCache(t *testing.T) {
+	const expected = `testdata/toolchain/toolchain.go:6:13:	return ints[0]: Found IsInBounds
+`
+	opts := Options{CacheDir: t.TempDir()}
+	var w strings.Builder
+	err := GCAssertWithOptions(&w, opts, "./testdata/toolchain", "./testdata/tests")
+	assert.Equal(t, ErrAssertionsFailed, err)
+	assert.Equal(t, expected+"gcassert: 2 directives checked, 1 failed (1 bce)\n", withoutLog(w.String()))
+	entries, err := os.ReadDir(opts.CacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, entries, 2)
+
+	// The second run reads the output of both packages from the cache.
+	w.Reset()
+	err = GCAssertWithOptions(&w, opts, "./testdata/toolchain", "./testdata/tests")
+	assert.Equal(t, ErrAssertionsFailed, err)
+	assert.Equal(t, expected+"gcassert: 2 directives checked, 1 failed (1 bce)\n", withoutLog(w.String()))
+
+	opts.ClearCache = true
+	w.Reset()
+	err = GCAssertWithOptions(&w, opts, "./testdata/toolchain")
+	assert.Equal(t, ErrAssertionsFailed, err)
+	assert.Equal(t, expected+"gcassert: 1 directive checked, 1 failed (1 bce)\n", withoutLog(w.String()))
+	entries, err = os.ReadDir(opts.CacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, entries, 1)
+}
+
+func TestGCAssertLoadErrors(t *testing.T) {
+	var w strings.Builder
+	err := GCAssert(&w)
+	assert.EqualError(t, err, "no packages specified")
+	assert.Equal(t, ExitConfigError, ExitCode(err))
+
+	err = GCAssert(&w, "./testdata/missing")
+	assert.Error(t, err)
+	assert.Equal(t, ExitConfigError, ExitCode(err))
+	assert.Equal(t, "", withoutLog(w.String()))
+
+	err = GCAssert(&w, "./testdata/typeerror")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "packages failed to load, so their directives weren't checked")
+		assert.Contains(t, err.Error(), "typeerror.go:5:9: cannot use ints[0] (variable of type int) as string value")
+	}
+	assert.Equal(t, ExitBuildFailed, ExitCode(err))
+	assert.Equal(t, "", withoutLog(w.String()))
+}
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, ExitOK, ExitCode(nil))
+	assert.Equal(t, ExitAssertionsFailed, ExitCode(ErrAssertionsFailed))
+	assert.Equal(t, ExitAssertionsFailed, ExitCode(fmt.Errorf("go1.22.0: %w", ErrAssertionsFailed)))
+	assert.Equal(t, ExitBuildFailed, ExitCode(&BuildError{Err: errors.New("exit status 1")}))
+	assert.Equal(t, ExitBuildFailed, ExitCode(fmt.Errorf("go1.22.0: %w", &BuildError{Err: errors.New("exit status 1")})))
+	assert.Equal(t, ExitConfigError, ExitCode(errors.New("no packages specified")))
+}
+
+func TestGCAssertFailOn(t *testing.T) {
+	const expected = `testdata/toolchain/toolchain.go:6:13:	return ints[0]: Found IsInBounds
+`
+	// The bce failure is a warning, so it's written but not returned.
+	var w strings.Builder
+	err := GCAssertWithOptions(&w, Options{FailOn: []string{"inline"}}, "./testdata/toolchain")
+	assert.NoError(t, err)
+	assert.Equal(t, expected+"gcassert: 1 directive checked, 1 failed (1 bce)\n", withoutLog(w.String()))
+
+	w.Reset()
+	err = GCAssertWithOptions(&w, Options{FailOn: []string{"inline", "bce"}}, "./testdata/toolchain")
+	assert.Equal(t, ErrAssertionsFailed, err)
+	assert.Equal(t, expected+"gcassert: 1 directive checked, 1 failed (1 bce)\n", withoutLog(w.String()))
+
+	w.Reset()
+	err = GCAssertWithOptions(&w, Options{NilOnFailure: true}, "./testdata/toolchain")
+	assert.NoError(t, err)
+	assert.Equal(t, expected, withoutLog(w.String()))
+
+	// Nothing is written when no directive fails.
+	w.Reset()
+	err = GCAssertWithOptions(&w, Options{NilOnFailure: true}, "./testdata/layout")
+	assert.NoError(t, err)
+	assert.Empty(t, w.String())
+}
+
+func TestGCAssertDirectivesFile(t *testing.T) {
+	var w strings.Builder
+	opts := Options{DirectivesFile: "testdata/external/gen.gcassert"}
+	err := GCAssertWithOptions(&w, opts, "./testdata/external")
+	assert.Equal(t, ErrAssertionsFailed, err)
+	assert.Equal(t, `testdata/external/gen.go:18:14:	return table[3]: Found IsInBounds
+gcassert: 2 directives checked, 1 failed (1 bce)
+`, withoutLog(w.String()))
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "bad.gcassert")
+	if err := os.WriteFile(path, []byte("gen.go:5\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err = GCAssertWithOptions(&w, Options{DirectivesFile: path}, "./testdata/external")
+	assert.EqualError(t, err, path+`:1: malformed line "gen.go:5", expected a file:line position and directives`)
+
+	if err := os.WriteFile(path, []byte("gen.go:3 bce\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err = GCAssertWithOptions(&w, Options{DirectivesFile: path}, "./testdata/external")
+	assert.EqualError(t, err, filepath.Join(dir, "gen.go")+`:3: directives "bce" aren't on a line of code in the loaded packages`)
+}
+
+func TestGCAssertPaths(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		name     string
+		opts     Options
+		expected string
+	}{
+		{
+			name:     "relative",
+			expected: "testdata/toolchain/toolchain.go",
+		},
+		{
+			name:     "absolute",
+			opts:     Options{AbsPaths: true, PathRoot: "testdata"},
+			expected: filepath.Join(cwd, "testdata/toolchain/toolchain.go"),
+		},
+		{
+			name:     "root",
+			opts:     Options{PathRoot: "testdata"},
+			expected: "toolchain/toolchain.go",
+		},
+		{
+			name:     "absolute root",
+			opts:     Options{PathRoot: filepath.Join(cwd, "testdata/toolchain")},
+			expected: "toolchain.go",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var w strings.Builder
+			testCase.opts.NoSummary = true
+			err := GCAssertWithOptions(&w, testCase.opts, "./testdata/toolchain")
+			assert.Equal(t, ErrAssertionsFailed, err)
+			assert.Equal(t, testCase.expected+":6:13:\treturn ints[0]: Found IsInBounds\n", withoutLog(w.String()))
+		})
+	}
+}
+
+func TestGCAssertIgnoreMessages(t *testing.T) {
+	var w strings.Builder
+	opts := Options{IgnoreMessages: []*regexp.Regexp{regexp.MustCompile(`^Found IsInBounds$`)}}
+	err := GCAssertWithOptions(&w, opts, "./testdata/toolchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "gcassert: 1 directive checked, 0 failed\n", withoutLog(w.String()))
+}
+
+func TestGCAssertTarget(t *testing.T) {
+	var w strings.Builder
+	err := GCAssertWithOptions(&w, Options{GOOS: "linux", GOARCH: "arm64"}, "./testdata/toolchain")
+	assert.Equal(t, ErrAssertionsFailed, err)
+	assert.Equal(t, `testdata/toolchain/toolchain.go:6:13:	return ints[0]: Found IsInBounds
+gcassert: 1 directive checked, 1 failed (1 bce)
+`, withoutLog(w.String()))
+}
+
+func TestGCAssertTags(t *testing.T) {
+	var w strings.Builder
+	if err := GCAssertWithOptions(&w, Options{}, "./testdata/tags"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "gcassert: 1 directive checked, 0 failed\n", withoutLog(w.String()))
+
+	w.Reset()
+	err := GCAssertWithOptions(&w, Options{Tags: []string{"gcassert_tagged"}}, "./testdata/tags")
+	assert.Equal(t, ErrAssertionsFailed, err)
+	assert.Equal(t, `testdata/tags/tagged.go:8:13:	return ints[0]: Found IsInBounds
+gcassert: 2 directives checked, 1 failed (1 bce)
+`, withoutLog(w.String()))
+}
+
+func TestGCAssertLog(t *testing.T) {
+	var w strings.Builder
+	err := GCAssertWithOptions(&w, Options{}, "./testdata/toolchain")
+	assert.Equal(t, ErrAssertionsFailed, err)
+	m := logNotice.FindStringSubmatch(w.String())
+	if m == nil {
+		t.Fatalf("no log path in %q", w.String())
+	}
+	assert.True(t, strings.HasPrefix(w.String(), m[0]))
+	log, err := os.ReadFile(m[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(m[1])
+	assert.Contains(t, string(log), "Found IsInBounds")
+
+	// The log is only kept if a directive fails.
+	w.Reset()
+	err = GCAssertWithOptions(&w, Options{DeleteLog: true}, "./testdata/toolchain")
+	assert.Equal(t, ErrAssertionsFailed, err)
+	m = logNotice.FindStringSubmatch(w.String())
+	if m == nil {
+		t.Fatalf("no log path in %q", w.String())
+	}
+	os.Remove(m[1])
+	w.Reset()
+	err = GCAssertWithOptions(&w, Options{DeleteLog: true, GCFlags: []string{"-B"}}, "./testdata/toolchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "gcassert: 1 directive checked, 0 failed\n", w.String())
+
+	w.Reset()
+	err = GCAssertWithOptions(&w, Options{NoLog: true}, "./testdata/toolchain")
+	assert.Equal(t, ErrAssertionsFailed, err)
+	assert.Equal(t, `testdata/toolchain/toolchain.go:6:13:	return ints[0]: Found IsInBounds
+gcassert: 1 directive checked, 1 failed (1 bce)
+`, w.String())
+}
+
+func TestGCAssertStdout(t *testing.T) {
+	// Everything that GCAssert writes, including the path of the log, goes
+	// to w, so that tools that embed it control their own output.
+	r, stdout, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	orig := os.Stdout
+	os.Stdout = stdout
+	var w strings.Builder
+	err = GCAssert(&w, "./testdata/toolchain")
+	os.Stdout = orig
+	stdout.Close()
+	assert.Equal(t, ErrAssertionsFailed, err)
+	written, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, string(written))
+	m := logNotice.FindStringSubmatch(w.String())
+	if m == nil {
+		t.Fatalf("no log path in %q", w.String())
+	}
+	os.Remove(m[1])
+}
+
+// logNotice matches the line that GCAssertWithOptions writes with the path of
+// the log of the compiler's full output, which differs between runs.
+var logNotice = regexp.MustCompile(`(?m)^(?:go[\w.]+: )?See (.+) for full output\.\n`)
+
+// withoutLog removes the line with the path of the log from output.
+func withoutLog(output string) string {
+	return logNotice.ReplaceAllString(output, "")
+}
+
+func TestGCAssertDeps(t *testing.T) {
+	var w strings.Builder
+	if err := GCAssertWithOptions(&w, Options{NoLog: true}, "./testdata/deps"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "gcassert: 1 directive checked, 0 failed\n", w.String())
+
+	w.Reset()
+	err := GCAssertWithOptions(&w, Options{Deps: true, NoLog: true}, "./testdata/deps")
+	assert.Equal(t, ErrAssertionsFailed, err)
+	assert.Equal(t, `testdata/deps/dep/dep.go:8:13:	return ints[0]: Found IsInBounds
+gcassert: 2 directives checked, 1 failed (1 bce)
+`, w.String())
+
+	// The test variants and the generated test main are built by naming
+	// the package that they test.
+	w.Reset()
+	err = GCAssertWithOptions(&w, Options{Tests: true, Deps: true, NoLog: true}, "./testdata/tests")
+	assert.Equal(t, ErrAssertionsFailed, err)
+	assert.Equal(t, "testdata/tests/sum_test.go:12:15:\tsink += ints[i]: Found IsInBounds\ngcassert: 3 directives checked, 1 failed (1 bce)\n", w.String())
+}
+
+func TestGCAssertGCFlags(t *testing.T) {
+	// -B disables bounds checks, so the bce directive holds.
+	var w strings.Builder
+	err := GCAssertWithOptions(&w, Options{GCFlags: []string{"-B"}}, "./testdata/toolchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "gcassert: 1 directive checked, 0 failed\n", withoutLog(w.String()))
+}
+
+func TestGCAssertResults(t *testing.T) {
+	failures, err := GCAssertResults("./testdata/toolchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []Failure{{
+		File:        "testdata/toolchain/toolchain.go",
+		Line:        6,
+		Col:         13,
+		Directive:   "bce",
+		Message:     "Found IsInBounds",
+		Source:      "return ints[0]",
+		compilerPos: true,
+		nodeLine:    6,
+		nodeCol:     2,
+	}}, failures)
+
+	// The failures of directives that aren't in FailOn are warnings.
+	failures, err = GCAssertResultsWithOptions(Options{FailOn: []string{"inline"}}, "./testdata/toolchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, failures, 1)
+	assert.True(t, failures[0].Warning)
+}
+
+func TestGCAssertJSON(t *testing.T) {
+	var w strings.Builder
+	err := GCAssertJSON(&w, "./testdata/toolchain")
+	assert.Equal(t, ErrAssertionsFailed, err)
+	assert.Equal(t, `{"file":"testdata/toolchain/toolchain.go","line":6,"column":13,"directive":"bce","message":"Found IsInBounds","source":"return ints[0]"}
+`, w.String())
+}
+
+func TestGCAssertSARIF(t *testing.T) {
+	var w strings.Builder
+	err := GCAssertSARIF(&w, "./testdata/toolchain")
+	assert.Equal(t, ErrAssertionsFailed, err)
+	assert.Equal(t, `{
+  "version": "2.1.0",
+  "$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+  "runs": [
+    {
+      "tool": {
+        "driver": {
+          "name": "gcassert",
+          "informationUri": "https://github.com/fmstephe/gcassert",
+          "rules": [
+            {
+              "id": "gcassert/bce",
+              "shortDescription": {
+                "text": "The //gcassert:bce directive wasn't upheld by the compiler"
+              }
+            }
+          ]
+        }
+      },
+      "results": [
+        {
+          "ruleId": "gcassert/bce",
+          "level": "error",
+          "message": {
+            "text": "Found IsInBounds"
+          },
+          "locations": [
+            {
+              "physicalLocation": {
+                "artifactLocation": {
+                  "uri": "testdata/toolchain/toolchain.go"
+                },
+                "region": {
+                  "startLine": 6,
+                  "startColumn": 13
+                }
+              }
+            }
+          ]
+        }
+      ]
+    }
+  ]
+}
+`, w.String())
+
+	// The failures of directives that aren't in FailOn are warnings, which
+	// don't fail the run.
+	w.Reset()
+	err = GCAssertSARIFWithOptions(&w, Options{FailOn: []string{"inline"}}, "./testdata/toolchain")
+	assert.NoError(t, err)
+	assert.Contains(t, w.String(), `"level": "warning"`)
+	assert.NotContains(t, w.String(), `"level": "error"`)
+}
+
+func TestGCAssertCoverage(t *testing.T) {
+	var w strings.Builder
+	err := GCAssertCoverage(&w, Options{}, "./testdata/coverage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `mode: set
+github.com/fmstephe/gcassert/testdata/coverage/coverage.go:4.5,4.30 1 1
+github.com/fmstephe/gcassert/testdata/coverage/coverage.go:6.5,6.31 1 0
+github.com/fmstephe/gcassert/testdata/coverage/coverage.go:12.2,12.17 1 0
+github.com/fmstephe/gcassert/testdata/coverage/coverage.go:17.2,17.12 1 1
+github.com/fmstephe/gcassert/testdata/coverage/coverage.go:19.3,19.21 1 1
+github.com/fmstephe/gcassert/testdata/coverage/coverage.go:21.2,21.14 1 1
+github.com/fmstephe/gcassert/testdata/coverage/coverage.go:26.3,26.11 1 0
+github.com/fmstephe/gcassert/testdata/coverage/coverage.go:28.2,28.15 1 0
+github.com/fmstephe/gcassert/testdata/coverage/coverage.go:29.2,29.16 1 1
+github.com/fmstephe/gcassert/testdata/coverage/coverage.go:30.2,30.23 1 0
+github.com/fmstephe/gcassert/testdata/coverage/coverage.go:34.2,34.14 1 1
+`, w.String())
+}
+
+func TestGCAssertList(t *testing.T) {
+	var w strings.Builder
+	err := GCAssertList(&w, "./testdata/coverage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `testdata/coverage/coverage.go
+	4: staticinit
+	16: noescape
+	19: inline call to lookup at column 18
+	29: bce
+`, w.String())
+}
+
+func TestGCAssertTrimpath(t *testing.T) {
+	// With -trimpath, the compiler prints each file's path as its package's
+	// import path joined with the file name.
+	t.Setenv("GOFLAGS", strings.TrimSpace(os.Getenv("GOFLAGS")+" -trimpath"))
+	var w strings.Builder
+	err := GCAssert(&w, "./testdata/toolchain")
+	assert.Equal(t, ErrAssertionsFailed, err)
+	assert.Equal(t, `testdata/toolchain/toolchain.go:6:13:	return ints[0]: Found IsInBounds
+gcassert: 1 directive checked, 1 failed (1 bce)
+`, withoutLog(w.String()))
+}
+
+func TestGCAssertStrictDirectives(t *testing.T) {
+	var w strings.Builder
+	err := GCAssertWithOptions(&w, Options{StrictDirectives: true}, "./testdata/strict")
+	assert.Equal(t, ErrAssertionsFailed, err)
+	assert.Equal(t, `testdata/strict/strict.go:9:	//gcassert:bce,: malformed directive comment, expected a comma-separated list of directives such as //gcassert:inline,bce
+testdata/strict/strict.go:10:	//gc-assert:bce: malformed directive comment, expected a comma-separated list of directives such as //gcassert:inline,bce
+testdata/strict/strict.go:11:	// gcassert:bce because i is in range: malformed directive comment, expected a comma-separated list of directives such as //gcassert:inline,bce
+testdata/strict/strict.go:12:	//GCAssert:bce: malformed directive comment, expected a comma-separated list of directives such as //gcassert:inline,bce
+testdata/strict/strict.go:45:	// This assertion should fail, because nothing calls unused, so whether it's
+// inlined is never checked.
+//
+//gcassert:inline
+func unused(x int) int {
+	return x + 1
+}: function is never called in the checked packages
+testdata/strict/strict.go:23:	n := len(ints): directive matched no analyzable expression
+testdata/strict/strict.go:25:	n *= 2: directive matched no analyzable expression
+gcassert: 8 directives checked, 3 failed (1 bce, 1 inline, 1 noescape), 4 malformed
+`, withoutLog(w.String()))
+}
+
+func TestGCAssertRewriteMessage(t *testing.T) {
+	var w strings.Builder
+	opts := Options{
+		RewriteMessage: func(directive, message string) string {
+			if directive == "bce" && message == "Found IsInBounds" {
+				return "index may be out of range, so it's bounds checked"
+			}
+			return message
+		},
+	}
+	err := GCAssertWithOptions(&w, opts, "./testdata/toolchain")
+	assert.Equal(t, ErrAssertionsFailed, err)
+	assert.Equal(t, `testdata/toolchain/toolchain.go:6:13:	return ints[0]: index may be out of range, so it's bounds checked
+gcassert: 1 directive checked, 1 failed (1 bce)
+`, withoutLog(w.String()))
+}
+
+func TestGCAssertEscapeTrace(t *testing.T) {
+	var w strings.Builder
+	err := GCAssertWithOptions(&w, Options{EscapeTrace: true}, "./testdata/escapetrace")
+	assert.Equal(t, ErrAssertionsFailed, err)
+	assert.Equal(t, `testdata/escapetrace/escapetrace.go:11:7:	p := &pair{a: n}: &pair{...} escapes to heap: &pair{...} (16 bytes)
+	testdata/escapetrace/escapetrace.go:11:7: &pair{...} escapes to heap:
+	testdata/escapetrace/escapetrace.go:11:7:   flow: p = &{storage for &pair{...}}:
+	testdata/escapetrace/escapetrace.go:11:7:     from &pair{...} (spill) at testdata/escapetrace/escapetrace.go:11:7
+	testdata/escapetrace/escapetrace.go:11:7:     from p := &pair{...} (assign) at testdata/escapetrace/escapetrace.go:11:4
+	testdata/escapetrace/escapetrace.go:11:7:   flow: {heap} = p:
+	testdata/escapetrace/escapetrace.go:11:7:     from sink = p (assign) at testdata/escapetrace/escapetrace.go:13:7
+	testdata/escapetrace/escapetrace.go:11:7: &pair{...} escapes to heap
+	testdata/escapetrace/escapetrace.go:12:7: &pair{...} does not escape
+gcassert: 1 directive checked, 1 failed (1 noescape)
+`, withoutLog(w.String()))
+}
+
+func TestGCAssertLines(t *testing.T) {
+	testCases := []struct {
+		name     string
+		lines    []LineRange
+		expected string
+	}{
+		{
+			name:     "changed",
+			lines:    []LineRange{{File: "testdata/toolchain/toolchain.go", Start: 5, End: 7}},
+			expected: "testdata/toolchain/toolchain.go:6:13:\treturn ints[0]: Found IsInBounds\n",
+		},
+		{
+			name:  "unchanged",
+			lines: []LineRange{{File: "testdata/toolchain/toolchain.go", Start: 1, End: 4}},
+		},
+		{
+			name:  "other file",
+			lines: []LineRange{{File: "testdata/race/race.go", Start: 1, End: 100}},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var w strings.Builder
+			err := GCAssertWithOptions(&w, Options{Lines: testCase.lines, NoSummary: true}, "./testdata/toolchain")
+			if testCase.expected == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.Equal(t, ErrAssertionsFailed, err)
 			}
-			assert.Equal(t, testCase.expected, w.String())
+			assert.Equal(t, testCase.expected, withoutLog(w.String()))
 		})
 	}
 }
+
+func TestGCAssertInternal(t *testing.T) {
+	var w strings.Builder
+	err := GCAssert(&w, "./testdata/layout/...")
+	assert.Equal(t, ErrAssertionsFailed, err)
+	assert.Equal(t, `testdata/layout/internal/fastpath/fastpath.go:14:19:	return sum + ints[0]: Found IsInBounds
+gcassert: 4 directives checked, 1 failed (1 bce)
+`, withoutLog(w.String()))
+}
+
+func TestAnalyzer(t *testing.T) {
+	fileSet := token.NewFileSet()
+	pkgs, err := packages.Load(&packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedCompiledGoFiles |
+			packages.NeedTypesInfo | packages.NeedTypes | packages.NeedTypesSizes,
+		Fset: fileSet,
+	}, "./testdata/toolchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := pkgs[0]
+	var diagnostics []string
+	pass := &analysis.Pass{
+		Analyzer:   Analyzer,
+		Fset:       fileSet,
+		Files:      pkg.Syntax,
+		Pkg:        pkg.Types,
+		TypesInfo:  pkg.TypesInfo,
+		TypesSizes: pkg.TypesSizes,
+		Report: func(d analysis.Diagnostic) {
+			pos := fileSet.Position(d.Pos)
+			diagnostics = append(diagnostics, fmt.Sprintf("%s:%d:%d: %s", filepath.Base(pos.Filename), pos.Line, pos.Column, d.Message))
+		},
+	}
+	if _, err := Analyzer.Run(pass); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"toolchain.go:6:13: bce: Found IsInBounds"}, diagnostics)
+}
+
+func TestOptInfo(t *testing.T) {
+	testCases := []struct {
+		name, line                   string
+		path, lineNo, colNo, message string
+	}{
+		{
+			name:    "relative",
+			line:    "testdata/bce.go:10:5: Found IsInBounds",
+			path:    "testdata/bce.go",
+			lineNo:  "10",
+			colNo:   "5",
+			message: "Found IsInBounds",
+		},
+		{
+			name:    "windows relative",
+			line:    `.\testdata\bce.go:10:5: Found IsInBounds`,
+			path:    `.\testdata\bce.go`,
+			lineNo:  "10",
+			colNo:   "5",
+			message: "Found IsInBounds",
+		},
+		{
+			name:    "windows absolute",
+			line:    `C:\Users\dev\gcassert\testdata\bce.go:10:5: inlining call to add`,
+			path:    `C:\Users\dev\gcassert\testdata\bce.go`,
+			lineNo:  "10",
+			colNo:   "5",
+			message: "inlining call to add",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			matches := optInfo.FindStringSubmatch(testCase.line)
+			assert.Equal(t, []string{testCase.line, testCase.path, testCase.lineNo, testCase.colNo, testCase.message}, matches)
+		})
+	}
+}
+
+func TestReporterDedupe(t *testing.T) {
+	fileSet := token.NewFileSet()
+	file, err := parser.ParseFile(fileSet, "dedupe.go", `package dedupe
+
+func first(ints []int) int {
+	return ints[0] + ints[1]
+}
+`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt := file.Decls[0].(*ast.FuncDecl).Body.List[0]
+	r := &reporter{fileSet: fileSet}
+	r.failAt(stmt, bce, "Found IsInBounds", 4, 13)
+	r.failAt(stmt, bce, "Found IsInBounds", 4, 13)
+	// The failures of the other index expression, and of another directive,
+	// on the same line are distinct.
+	r.failAt(stmt, bce, "Found IsInBounds", 4, 23)
+	r.fail(stmt, inline, "call was not inlined")
+	r.fail(stmt, inline, "call was not inlined")
+	var w strings.Builder
+	if err := writeFailures(&w, r.failures); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `dedupe.go:4:13:	return ints[0] + ints[1]: Found IsInBounds
+dedupe.go:4:23:	return ints[0] + ints[1]: Found IsInBounds
+dedupe.go:4:	return ints[0] + ints[1]: call was not inlined
+`, withoutLog(w.String()))
+}
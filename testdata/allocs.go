@@ -0,0 +1,32 @@
+package gcassert
+
+type allocPair struct {
+	a, b int
+}
+
+var allocSink []*allocPair
+
+// This assertion should pass, because both pairs escape to the heap.
+//
+//gcassert:allocs:2
+func allocTwice() {
+	p := &allocPair{a: 1}
+	q := &allocPair{b: 2}
+	allocSink = append(allocSink, p, q)
+}
+
+// This assertion should fail, because the function allocates three times.
+//
+//gcassert:allocs:2
+func allocThrice(n int) {
+	var p allocPair
+	allocSink = append(allocSink, &p, &allocPair{a: n}, &allocPair{b: n})
+}
+
+// This assertion should pass, because the returned channel is allocated by the
+// runtime, which escape analysis doesn't report.
+//
+//gcassert:allocs:1
+func newChannel() chan int {
+	return make(chan int, 1)
+}
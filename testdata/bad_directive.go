@@ -12,3 +12,28 @@ func badDirective2() {
 func badDirective3() {
 	badDirective2()
 }
+
+func badDirective4() {
+	//gcassert:allocs:many,bce:1
+	badDirective3()
+}
+
+func badDirective5() {
+	//gcassert:register=2x
+	badDirective4()
+}
+
+func badDirective6() {
+	//gcassert:!bce
+	badDirective5()
+}
+
+func badDirective7() {
+	//gcassert:bce+x,inline+2
+	badDirective6()
+}
+
+func badDirective8() {
+	//gcassert:ssa=cse
+	badDirective7()
+}
@@ -0,0 +1,25 @@
+package gcassert
+
+func sumArray(arr [8]int, i int) int {
+	// These assertions should pass, because the indexes are constant or
+	// bounded by the array's length.
+	sum := arr[3] //gcassert:bce
+	for j := range arr {
+		sum += arr[j] //gcassert:bce
+	}
+	sum += arr[i&7] //gcassert:bce
+
+	// This assertion should fail, because nothing bounds i.
+	sum += arr[i] //gcassert:bce
+	return sum
+}
+
+func sumArrayPtr(arr *[8]int, i int) int {
+	sum := 0
+	for j := 0; j < len(arr); j++ {
+		sum += arr[j] //gcassert:bce
+	}
+	// This assertion should fail, because nothing bounds i.
+	sum += arr[i] //gcassert:bce
+	return sum
+}
@@ -0,0 +1,22 @@
+package gcassert
+
+// This assertion should pass, because the hint leaves a single bounds check.
+//
+//gcassert:bcemerge
+func littleEndian16(b []byte) uint16 {
+	_ = b[1]
+	return uint16(b[0]) | uint16(b[1])<<8
+}
+
+// This assertion should fail, because b[0] and b[1] are checked separately.
+//
+//gcassert:bcemerge
+func littleEndian16Unmerged(b []byte) uint16 {
+	return uint16(b[0]) | uint16(b[1])<<8
+}
+
+func adjacentBytes(b []byte) uint16 {
+	// This assertion should fail, because i and i+1 are checked separately.
+	i := len(b) / 2
+	return uint16(b[i]) | uint16(b[i+1])<<8 //gcassert:bcemerge
+}
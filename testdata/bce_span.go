@@ -0,0 +1,27 @@
+package gcassert
+
+// The range of lines should fail on the second and third lines, because only
+// ints[0] is known to be in bounds. The third line has a directive of its own,
+// so it's only reported once.
+func spanSum(ints []int) int {
+	if len(ints) < 1 {
+		return 0
+	}
+	//gcassert:bce+2
+	a := ints[0]
+	b := ints[1]
+	c := ints[2] //gcassert:bce
+	return a + b + c
+}
+
+// This assertion should pass, because every index in the loop body is bounded
+// by the range.
+func spanLoop(ints []int) int {
+	sum := 0
+	//gcassert:bce+3
+	for i := range ints {
+		sum += ints[i]
+		sum -= ints[i] >> 1
+	}
+	return sum
+}
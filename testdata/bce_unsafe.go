@@ -0,0 +1,22 @@
+package gcassert
+
+import "unsafe"
+
+// sumWords sums the n words that p points to. unsafe.Slice doesn't bounds
+// check, but indexing the slice it makes does, unless the compiler can prove
+// the index is in range.
+func sumWords(p *uint64, n int) uint64 {
+	words := unsafe.Slice(p, n)
+	var sum uint64
+	for i := 0; i < n; i++ {
+		// This assertion should pass, because the length of words is n.
+		sum += words[i] //gcassert:bce
+	}
+	if len(words) >= 4 {
+		// This assertion should pass, because of the length guard.
+		sum += words[3] //gcassert:bce
+	}
+	// This assertion should fail, because nothing proves words isn't empty.
+	sum += words[0] //gcassert:bce
+	return sum
+}
@@ -0,0 +1,41 @@
+package gcassert
+
+func square(x int) int {
+	return x * x
+}
+
+//go:noinline
+func cube(x int) int {
+	return x * x * x
+}
+
+func sumSquares(xs []int) int {
+	sum := 0
+	// This assertion should pass, because square is inlined.
+	//
+	//gcassert:callfree
+	for _, x := range xs {
+		sum += square(x)
+	}
+	return sum
+}
+
+func sumCubes(xs []int) int {
+	sum := 0
+	// This assertion should fail, because cube can't be inlined.
+	//
+	//gcassert:callfree
+	for _, x := range xs {
+		sum += cube(x)
+	}
+	return sum
+}
+
+func countWords(words []string, counts map[string]int) {
+	// This assertion should fail, because updating a map calls the runtime.
+	//
+	//gcassert:callfree
+	for _, w := range words {
+		counts[w]++
+	}
+}
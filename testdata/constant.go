@@ -0,0 +1,21 @@
+package gcassert
+
+import "unsafe"
+
+type constantHeader struct {
+	magic   uint32
+	version uint16
+}
+
+// This assertion should pass, because the size of a type is known at compile
+// time.
+//
+//gcassert:const
+var constantHeaderSize = unsafe.Sizeof(constantHeader{}) * 2
+
+func constantPrefix(s []byte) int {
+	// This assertion should fail, because the length of s is only known at
+	// run time.
+	n := len(s) * 2 //gcassert:const
+	return n + int(constantHeaderSize)
+}
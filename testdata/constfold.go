@@ -0,0 +1,15 @@
+package gcassert
+
+func sumOfSquares(a, b int) int {
+	return a*a + b*b
+}
+
+func foldedSum() int {
+	// This assertion should pass, because the arguments are constant.
+	return sumOfSquares(3, 4) //gcassert:constfold
+}
+
+func unfoldedSum(x int) int {
+	// This assertion should fail, because x is only known at run time.
+	return sumOfSquares(x, 4) //gcassert:constfold
+}
@@ -0,0 +1,35 @@
+package coverage
+
+//gcassert:staticinit
+var table = [...]int{1, 2, 3}
+
+var unchecked = []int{4, 5, 6}
+
+const limit = 3
+
+//gcassert:inline
+func lookup(i int) int {
+	return table[i]
+}
+
+//gcassert:noescape
+func sum() int {
+	total := 0
+	for i := 0; i < limit; i++ {
+		total += lookup(i)
+	}
+	return total
+}
+
+func mixed(s []int) int {
+	if len(s) < 2 {
+		return 0
+	}
+	first := s[0]
+	second := s[1] //gcassert:bce
+	return first + second
+}
+
+func total() int {
+	return sum()
+}
@@ -0,0 +1,9 @@
+package dep
+
+// First returns the first of ints.
+//
+// This assertion should fail, but it's only checked along with the
+// dependencies of the deps package.
+func First(ints []int) int {
+	return ints[0] //gcassert:bce
+}
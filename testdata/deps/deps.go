@@ -0,0 +1,11 @@
+package deps
+
+import "github.com/fmstephe/gcassert/testdata/deps/dep"
+
+func sum(ints []int) int {
+	s := 0
+	for i := range ints {
+		s += ints[i] //gcassert:bce
+	}
+	return s + dep.First(ints)
+}
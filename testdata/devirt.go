@@ -0,0 +1,30 @@
+package gcassert
+
+type tiler interface {
+	area() int
+}
+
+type tile struct {
+	side int
+}
+
+func (s tile) area() int {
+	return s.side * s.side
+}
+
+func tileArea(side int) int {
+	var s tiler = tile{side: side}
+	// This assertion should pass, because the concrete type of s is known.
+	//gcassert:devirt
+	return s.area()
+}
+
+func totalArea(tiles []tiler) int {
+	total := 0
+	for _, s := range tiles {
+		// This assertion should fail, because each element may have a
+		// different concrete type.
+		total += s.area() //gcassert:devirt
+	}
+	return total
+}
@@ -0,0 +1,61 @@
+package gcassert
+
+type scaler interface {
+	scale() int
+}
+
+type doubler struct {
+	n int
+}
+
+// This assertion applies to calls through scaler too, which must be
+// devirtualized before they can be inlined.
+//
+//gcassert:inline
+func (d doubler) scale() int {
+	return d.n * 2
+}
+
+func scaleDoublers(items []doubler) int {
+	sum := 0
+	for _, x := range items {
+		// This call should pass, because the concrete type of the interface
+		// value is known, so the call is devirtualized and then inlined.
+		sum += scaler(x).scale()
+	}
+	return sum
+}
+
+func scaleDoubler(d doubler) int {
+	// This call should pass, because s is only assigned a doubler, so the
+	// call is devirtualized and then inlined.
+	var s scaler = d
+	return s.scale()
+}
+
+func scaleAny(items []scaler) int {
+	sum := 0
+	for _, s := range items {
+		// This assertion should fail, because each element may have a
+		// different concrete type, so the call can't be devirtualized.
+		sum += s.scale() //gcassert:inline
+	}
+	return sum
+}
+
+type tripler struct {
+	n int
+}
+
+// This assertion should pass, because the call through scaler that converts a
+// tripler is attributed to this method, rather than to doubler's.
+//
+//gcassert:noinline
+//go:noinline
+func (t tripler) scale() int {
+	return t.n * 3
+}
+
+func scaleTripler(n int) int {
+	return scaler(tripler{n: n}).scale()
+}
@@ -0,0 +1,28 @@
+package gcassert
+
+type opTable struct {
+	add func(a, b int) int
+	sub func(a, b int) int
+}
+
+func addInts(a, b int) int {
+	return a + b
+}
+
+func subInts(a, b int) int {
+	return a - b
+}
+
+var ops = opTable{add: addInts, sub: subInts}
+
+func applyOps(a, b int) int {
+	// This assertion should fail, because the call through the table's
+	// field is indirect.
+	sum := ops.add(a, b) //gcassert:inline
+	// This assertion should pass, because the call is direct.
+	sum += addInts(sum, b) //gcassert:inline
+	// This assertion should pass, because it names the direct call, which is
+	// inlined, rather than the call through the table's field.
+	sum = ops.sub(addInts(sum, a), b) //gcassert:inline=addInts
+	return sum
+}
@@ -0,0 +1,15 @@
+package escapetrace
+
+type pair struct {
+	a, b int
+}
+
+var sink *pair
+
+func keepFirst(n int) int {
+	//gcassert:noescape
+	p := &pair{a: n}
+	q := &pair{b: n}
+	sink = p
+	return q.b
+}
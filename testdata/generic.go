@@ -0,0 +1,57 @@
+package gcassert
+
+type adder interface {
+	add(int) int
+}
+
+type addInt int
+
+//gcassert:inline
+func (a addInt) add(i int) int {
+	return int(a) + i
+}
+
+type addFloat float64
+
+func (a addFloat) add(i int) int {
+	return int(a) + i
+}
+
+// This assertion should fail for the addInt instantiation, because the
+// compiler calls constraint methods through the instantiation's dictionary
+// rather than inlining them.
+func sumAdders[T adder](xs []T) int {
+	s := 0
+	for _, x := range xs {
+		s = x.add(s)
+	}
+	return s
+}
+
+// sumFloatAdders is only instantiated with addFloat, whose method isn't
+// marked inline, so its callsite is not checked.
+func sumFloatAdders[T adder](xs []T) int {
+	s := 0
+	for _, x := range xs {
+		s = x.add(s)
+	}
+	return s
+}
+
+func callSumAdders() int {
+	return sumAdders([]addInt{1, 2, 3}) + sumFloatAdders([]addFloat{1, 2, 3})
+}
+
+type addUint uint
+
+//gcassert:inline
+func (a addUint) add(i int) int {
+	return int(a) + i
+}
+
+// This assertion should fail for the addUint instantiation too, because the
+// call is checked against the method of each instantiation whose method is
+// marked inline.
+func callSumUintAdders() int {
+	return sumAdders([]addUint{1, 2, 3})
+}
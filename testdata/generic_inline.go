@@ -0,0 +1,39 @@
+package gcassert
+
+//gcassert:inline
+func maxOf[T int | float64](a, b T) T {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+type pair[T any] struct {
+	a, b T
+}
+
+//gcassert:inline
+func (p pair[T]) first() T {
+	return p.a
+}
+
+func lastOf[T any](xs []T) T {
+	if len(xs) == 0 {
+		var zero T
+		return zero
+	}
+	return xs[len(xs)-1] //gcassert:bce
+}
+
+func headOf[T any](xs []T) T {
+	return xs[0] //gcassert:bce
+}
+
+// Each call is to a different instantiation, whether its type arguments are
+// inferred or given explicitly.
+func useGenerics(i int, f float64, ints []int, strs []string) (int, float64, string) {
+	m := maxOf(i, 2) + maxOf[int](i, 3)
+	g := maxOf[float64](f, 2.5)
+	s := pair[string]{a: "a"}.first() + lastOf(strs) + headOf(strs)
+	return m + pair[int]{a: 1}.first() + lastOf[int](ints) + headOf(ints), g, s
+}
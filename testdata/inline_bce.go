@@ -0,0 +1,21 @@
+package gcassert
+
+//gcassert:inline
+func thirdByte(b []byte) byte {
+	return b[2]
+}
+
+func thirdByteGuarded(b []byte) byte {
+	if len(b) < 3 {
+		return 0
+	}
+	// This assertion should pass, because the length guard proves that the
+	// index in the inlined body is in range.
+	return thirdByte(b) //gcassert:inlinebce
+}
+
+func thirdByteUnguarded(b []byte) byte {
+	// This assertion should fail, because nothing proves that b has three
+	// bytes.
+	return thirdByte(b) //gcassert:inlinebce
+}
@@ -0,0 +1,31 @@
+package gcassert
+
+func incr(i int) int {
+	return i + 1
+}
+
+//go:noinline
+func slowIncr(i int) int {
+	return i + 1
+}
+
+func nestedCalls(a accum, i int) int {
+	// This assertion passes, because incr is inlined.
+	x := slowIncr(incr(i)) //gcassert:inline=incr
+	// This assertion should fail, because slowIncr isn't inlined, even
+	// though incr is.
+	y := slowIncr(incr(i)) //gcassert:inline=slowIncr
+	// Without a callee, the assertion passes if any call on the line is
+	// inlined.
+	z := slowIncr(incr(i)) //gcassert:inline
+	// A method is named without its receiver type.
+	return a.plus(slowIncr(x + y + z)) //gcassert:inline=plus
+}
+
+// This assertion should fail, because the directive on a function applies to
+// every call to it.
+//
+//gcassert:inline=incr
+func incrTwice(i int) int {
+	return incr(incr(i))
+}
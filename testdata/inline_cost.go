@@ -0,0 +1,46 @@
+package gcassert
+
+// This assertion should pass, because the function is a single addition.
+//
+//gcassert:inline,cost<=70
+func cheapAdd(a, b int) int {
+	return a + b
+}
+
+// This assertion should fail, because the function can be inlined, but the
+// loop costs more than the bound.
+//
+//gcassert:inline,cost<=5
+func pricierSum(ints []int) int {
+	total := 0
+	for i := range ints {
+		total += ints[i] * ints[i]
+	}
+	return total
+}
+
+// This assertion should fail, because the function costs more than the
+// compiler's inlining budget, so it can't be inlined at all.
+//
+//gcassert:cost<=80
+func tooComplex(ints []int) int {
+	total := 0
+	for i := range ints {
+		for j := range ints {
+			total += ints[i] * ints[j]
+			total ^= ints[j] >> 3
+			total += ints[i] % (ints[j] | 1)
+			total -= ints[i] / (ints[j] | 1)
+		}
+	}
+	for i := range ints {
+		total += ints[i] * ints[i]
+		total ^= ints[i] >> 3
+		total += ints[i] % (ints[i] | 1)
+	}
+	return total
+}
+
+func callCheapAdd() int {
+	return cheapAdd(1, 2)
+}
@@ -0,0 +1,41 @@
+package gcassert
+
+//gcassert:inline
+func deepIncr(x int) int {
+	return x + 1
+}
+
+// deepTimes3 is asserted to be inlined, but it can't be.
+//
+//gcassert:inline
+//go:noinline
+func deepTimes3(x int) int {
+	return x * 3
+}
+
+//gcassert:inlinedeep
+func deepAddTwo(x int) int {
+	return deepIncr(deepIncr(x))
+}
+
+//gcassert:inlinedeep
+func deepTimes3Plus1(x int) int {
+	return deepTimes3(x) + 1
+}
+
+func useDeep(x int) int {
+	// This call should pass, because both calls to deepIncr are inlined
+	// along with deepAddTwo.
+	a := deepAddTwo(x)
+	// This call should fail, because deepTimes3 is never inlined, even
+	// though deepTimes3Plus1 is.
+	b := deepTimes3Plus1(x)
+	return a + b
+}
+
+func deepMisplaced(x int) int {
+	// This assertion should fail, because inlinedeep only applies to
+	// functions.
+	//gcassert:inlinedeep
+	return deepAddTwo(x)
+}
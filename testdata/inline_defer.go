@@ -0,0 +1,24 @@
+package gcassert
+
+var deferred int
+
+//gcassert:inline
+func deferIncr(x int) {
+	deferred += x
+}
+
+//gcassert:inline
+//go:noinline
+func deferDecr(x int) {
+	deferred -= x
+}
+
+func useDeferred(x int) {
+	// These calls should pass, because the compiler inlines them into the
+	// wrappers that it generates for defer and go statements.
+	defer deferIncr(x)
+	go deferIncr(x)
+	// These calls should fail, because deferDecr can't be inlined.
+	defer deferDecr(x)
+	go deferDecr(x)
+}
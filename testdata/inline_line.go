@@ -0,0 +1,22 @@
+package gcassert
+
+//gcassert:inline
+func lineAdd(x int) int {
+	return x + 1
+}
+
+//gcassert:inline
+//go:noinline
+func lineSub(x int) int {
+	return x - 1
+}
+
+func lineCollision(x int) int {
+	a := lineAdd(x)
+	// This call should fail, even though the //line directive, like those
+	// in generated code, gives it the same position as the call above,
+	// which is inlined.
+//line inline_line.go:15:1
+	b := lineSub(x)
+	return a + b
+}
@@ -0,0 +1,28 @@
+package gcassert
+
+type scratch struct {
+	buf [64]byte
+	n   int
+}
+
+// newScratch allocates its result on the heap, because the result escapes.
+func newScratch() *scratch {
+	return &scratch{}
+}
+
+func useScratch(b byte) byte {
+	// This assertion should pass, because once newScratch is inlined the
+	// scratch doesn't escape useScratch, so it's allocated on the stack.
+	s := newScratch() //gcassert:inlinenoalloc
+	s.buf[s.n] = b
+	return s.buf[0]
+}
+
+var scratchSink *scratch
+
+func keepScratch() {
+	// This assertion should fail, because the scratch escapes keepScratch
+	// too, so it's still allocated on the heap.
+	s := newScratch() //gcassert:inlinenoalloc
+	scratchSink = s
+}
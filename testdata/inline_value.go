@@ -0,0 +1,36 @@
+package gcassert
+
+type accum int
+
+//gcassert:inline
+func (a accum) plus(i int) int {
+	return int(a) + i
+}
+
+//gcassert:inline
+func doubled(i int) int {
+	return 2 * i
+}
+
+// Each call through a variable that's only assigned when it's declared is a
+// call to the function that it's assigned.
+func callValues(a accum) int {
+	f := doubled
+	var g = (doubled)
+	s := f(1) + g(2) + (doubled)(3)
+	// This assertion should fail, because the compiler calls a method value
+	// through a wrapper that it doesn't inline.
+	p := a.plus
+	s += p(4)
+	// A variable that's assigned again isn't resolved.
+	h := doubled
+	h = func(i int) int { return i }
+	return s + h(5)
+}
+
+// This assertion should fail for the second call only, because it's through a
+// method value.
+func callBoth(a accum) int {
+	p := a.plus
+	return doubled(1) + p(2)
+}
@@ -0,0 +1,19 @@
+package gcassert
+
+type smallKey struct {
+	a, b int32
+}
+
+type largeKey struct {
+	a [16]int64
+}
+
+func equalKeys(x, y smallKey, p, q *largeKey) bool {
+	// This assertion should pass, because two int32 fields are compared with
+	// a single instruction.
+	small := x == y //gcassert:inlineeq
+	// This assertion should fail, because the compiler compares 128 bytes
+	// with runtime.memequal.
+	large := *p == *q //gcassert:inlineeq
+	return small && large
+}
@@ -0,0 +1,15 @@
+package fastpath
+
+//gcassert:inline
+func Double(i int) int {
+	return i * 2
+}
+
+func Sum(ints []int) int {
+	sum := 0
+	for i := range ints {
+		sum += ints[i] //gcassert:bce
+	}
+	// This assertion should fail, because nothing proves ints is non-empty.
+	return sum + ints[0] //gcassert:bce
+}
@@ -0,0 +1,9 @@
+package layout
+
+import "github.com/fmstephe/gcassert/testdata/layout/internal/fastpath"
+
+func quadruple(i int) int {
+	// This call is checked against the inline directive in the internal
+	// package.
+	return fastpath.Double(fastpath.Double(i))
+}
@@ -0,0 +1,14 @@
+package gcassert
+
+type largeValue [200]byte
+
+func lookupCount(counts map[string]int, key string) int {
+	// This assertion should pass, because the key is a string.
+	return counts[key] //gcassert:mapfaststr
+}
+
+func lookupLarge(values map[string]largeValue, key string) byte {
+	// This assertion should fail, because values larger than 128 bytes
+	// need the generic helper.
+	return values[key][0] //gcassert:mapfaststr
+}
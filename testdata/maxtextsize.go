@@ -0,0 +1,24 @@
+package gcassert
+
+// This assertion should pass, because the function is a handful of
+// instructions.
+//
+//gcassert:maxtextsize:64
+func smallText(a, b int) int {
+	return a*b + 1
+}
+
+// This assertion should fail, because the bounds checks and arithmetic in the
+// loop take far more than the limit.
+//
+//gcassert:maxtextsize:64
+func largeText(ints []int) int {
+	sum := 0
+	for i := 0; i+8 <= len(ints); i += 8 {
+		sum += ints[i] * ints[i+1]
+		sum += ints[i+2] * ints[i+3]
+		sum += ints[i+4] * ints[i+5]
+		sum += ints[i+6] * ints[i+7]
+	}
+	return sum
+}
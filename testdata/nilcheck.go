@@ -0,0 +1,28 @@
+package gcassert
+
+type listNode struct {
+	val  int
+	next *listNode
+}
+
+// This assertion should pass, because loading n.val faults if n is nil, so
+// the compiler doesn't need a separate nil check.
+func firstListVal(n *listNode) int {
+	return n.val //gcassert:nilcheck
+}
+
+// This assertion should fail, because the compiler checks p for nil in the
+// loop body, rather than once before the loop.
+func sumNilChecked(p *[8]int) int {
+	s := 0
+	for i := 0; i < 8; i++ {
+		s += p[i] //gcassert:nilcheck
+	}
+	return s
+}
+
+// This assertion should fail, because a value receiver has no nil check to
+// remove.
+func (n listNode) value() int {
+	return n.val //gcassert:nilcheck
+}
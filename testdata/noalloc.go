@@ -0,0 +1,49 @@
+package gcassert
+
+import "fmt"
+
+func sumValues(m map[string]int) int {
+	sum := 0
+	// This assertion should pass, because the loop body doesn't allocate.
+	//
+	//gcassert:noalloc
+	for _, v := range m {
+		sum += v
+	}
+	return sum
+}
+
+var noallocSink []fmt.Stringer
+
+type namedValue struct {
+	name  string
+	value int
+}
+
+func (n namedValue) String() string {
+	return n.name
+}
+
+func collectValues(m map[string]int) {
+	// This assertion should fail, because converting each namedValue to an
+	// interface allocates on every iteration.
+	//
+	//gcassert:noalloc
+	for k, v := range m {
+		noallocSink = append(noallocSink, namedValue{name: k, value: v})
+	}
+}
+
+func drainValues(m map[string]int) int {
+	sum := 0
+	// This assertion should fail, because a channel is always allocated on
+	// the heap, even though this one doesn't escape.
+	//
+	//gcassert:noalloc
+	for _, v := range m {
+		c := make(chan int, 1)
+		c <- v
+		sum += <-c
+	}
+	return sum
+}
@@ -0,0 +1,50 @@
+package gcassert
+
+import "encoding/binary"
+
+type record struct {
+	kind byte
+	id   uint32
+	name string
+}
+
+var recordKinds = map[string]byte{"user": 1, "group": 2}
+
+// This assertion should pass, because the encoder writes into a fixed buffer,
+// and the conversion of the kind to a string to look it up doesn't allocate.
+//
+//gcassert:noalloc
+func encodeRecord(buf *[16]byte, kind []byte, r record) []byte {
+	buf[0] = recordKinds[string(kind)]
+	binary.LittleEndian.PutUint32(buf[1:], r.id)
+	n := copy(buf[5:], r.name)
+	return buf[:5+n]
+}
+
+type encodeError struct {
+	name string
+}
+
+func (e *encodeError) Error() string {
+	return "name too long: " + e.name
+}
+
+func newEncodeError(name string) error {
+	return &encodeError{name: name}
+}
+
+var lastEncodeError error
+
+// This assertion should fail, because the error allocated by newEncodeError is
+// made by this function once newEncodeError is inlined.
+//
+//gcassert:noalloc
+func encodeRecordChecked(dst []byte, r record) []byte {
+	if len(r.name) > 11 {
+		lastEncodeError = newEncodeError(r.name)
+		return dst
+	}
+	dst = append(dst, r.kind)
+	dst = binary.LittleEndian.AppendUint32(dst, r.id)
+	return append(dst, r.name...)
+}
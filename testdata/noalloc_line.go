@@ -0,0 +1,30 @@
+package gcassert
+
+var lineSink any
+
+func boxValue(v int) {
+	// This assertion should fail, because converting v to an interface
+	// allocates.
+	lineSink = v //gcassert:noalloc
+}
+
+func captureValue(x int) func() int {
+	// This assertion should fail, because the closure escapes.
+	return func() int { return x } //gcassert:noalloc
+}
+
+func storeLocal(v int) int {
+	// This assertion should pass, because the array stays on the stack.
+	var buf [4]int
+	buf[v&3] = v //gcassert:noalloc
+	return buf[0]
+}
+
+func escapeOnce() *int {
+	// This assertion should fail only once, because noalloc doesn't report
+	// the allocation that noescape already reports.
+	//
+	//gcassert:noescape,noalloc
+	x := 1
+	return &x
+}
@@ -0,0 +1,35 @@
+package gcassert
+
+import "sync"
+
+type pooledBuffer struct {
+	b [64]byte
+}
+
+var pooledBufferPtrs = sync.Pool{New: func() any { return new(pooledBuffer) }}
+
+// This assertion should pass, because a pointer is stored in an interface
+// without boxing.
+//
+//gcassert:noalloc
+func usePooledBufferPtr() byte {
+	buf := pooledBufferPtrs.Get().(*pooledBuffer)
+	buf.b[0] = 1
+	x := buf.b[0]
+	pooledBufferPtrs.Put(buf)
+	return x
+}
+
+var pooledBufferValues = sync.Pool{New: func() any { return pooledBuffer{} }}
+
+// This assertion should fail, because putting a struct value into the pool
+// boxes it in a new heap allocation.
+//
+//gcassert:noalloc
+func usePooledBufferValue() byte {
+	buf := pooledBufferValues.Get().(pooledBuffer)
+	buf.b[0] = 1
+	x := buf.b[0]
+	pooledBufferValues.Put(buf)
+	return x
+}
@@ -0,0 +1,21 @@
+package gcassert
+
+type bigStruct struct {
+	a [64]int
+}
+
+var globalBigStruct bigStruct
+
+func elidedCopy() int {
+	var b bigStruct
+	b.a[3] = 4
+	// This assertion should pass, because b is dead after the copy.
+	a := b //gcassert:nocopy
+	return a.a[3]
+}
+
+func notElidedCopy(p *bigStruct) {
+	// This assertion should fail, because the global must hold its own copy.
+	//gcassert:nocopy
+	globalBigStruct = *p
+}
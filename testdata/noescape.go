@@ -29,7 +29,7 @@ func (f foo) setA(a int) *foo {
 	return &f
 }
 
-// This annotation should pass, because f does not escape.
+// This annotation should fail, because the parameter a escapes, unlike f.
 //
 //gcassert:noescape
 func (f foo) returnA(
@@ -57,3 +57,31 @@ func (f *foo) printReceiver() {
 func (f *foo) printValue() {
 	fmt.Printf("#v", *f)
 }
+
+var leakedFoo *foo
+
+// This annotation should fail, because the parameters p and n escape to the
+// heap, even though they're declared on lines of their own. It doesn't fail
+// because local escapes, since it isn't a parameter.
+//
+//gcassert:noescape
+func leakParams(
+	p *foo,
+	n int,
+) (*int, *foo) {
+	local := foo{a: n}
+	leakedFoo = p
+	return &n, &local
+}
+
+// This annotation should succeed, because only the local x that shadows the
+// parameter x escapes, and it's reported at its own position.
+//
+//gcassert:noescape
+func shadowParam(x int) *int {
+	if x > 0 {
+		x := x * 2
+		return &x
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package gcassert
+
+type fieldHolder struct {
+	x int
+}
+
+func (h *fieldHolder) field() *int {
+	return &h.x
+}
+
+var fieldSink *int
+
+func fieldStays() int {
+	var h fieldHolder
+	// This assertion should pass, because p doesn't outlive h.
+	p := h.field() //gcassert:noescapecall
+	*p = 3
+	return h.x
+}
+
+func fieldEscapes() {
+	var h fieldHolder
+	// This assertion should fail, because storing p in a global moves h to
+	// the heap.
+	p := h.field() //gcassert:noescapecall
+	fieldSink = p
+}
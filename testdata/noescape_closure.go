@@ -0,0 +1,22 @@
+package gcassert
+
+var storedCallback func()
+
+//go:noinline
+func storeCallback(f func()) {
+	storedCallback = f
+}
+
+//go:noinline
+func runCallback(f func()) {
+	f()
+}
+
+func passClosures() int {
+	x := 0
+	// This assertion should pass, because runCallback doesn't leak f.
+	runCallback(func() { x++ }) //gcassert:noescapeclosure
+	// This assertion should fail, because storeCallback leaks f to the heap.
+	storeCallback(func() { x++ }) //gcassert:noescapeclosure
+	return x
+}
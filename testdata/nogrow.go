@@ -0,0 +1,29 @@
+package gcassert
+
+func encodeHeader(version, flags, kind byte) int {
+	// This assertion should pass, because the compiler proves that the
+	// buffer's capacity is enough for three bytes.
+	buf := make([]byte, 0, 16)
+	buf = append(buf[:0], version, flags, kind) //gcassert:nogrow
+	return len(buf)
+}
+
+func encodeLongHeader(version, flags, kind byte) int {
+	// This assertion should fail, because the buffer must grow to hold three
+	// bytes.
+	buf := make([]byte, 0, 2)
+	buf = append(buf[:0], version, flags, kind) //gcassert:nogrow
+	return len(buf)
+}
+
+func reuseBuffer(buf, data []byte) []byte {
+	// This assertion should fail, because the capacity of a buffer passed in
+	// by the caller is unknown when the function is compiled.
+	buf = append(buf[:0], data...) //gcassert:nogrow
+	return buf
+}
+
+func addEntry(m map[string]int, k string) {
+	// This assertion should fail, because adding a key may grow the map.
+	m[k] = 1 //gcassert:nogrow
+}
@@ -0,0 +1,31 @@
+package gcassert
+
+// This assertion should fail, because profiled is small enough to be inlined
+// into its callers.
+//
+//gcassert:noinline
+func profiled(a int) int {
+	return a * 2
+}
+
+// This assertion should pass, because the go:noinline pragma keeps
+// profiledBoundary out of line, so it shows up as its own frame in profiles.
+//
+//gcassert:noinline
+//go:noinline
+func profiledBoundary(a int) int {
+	return a * 3
+}
+
+func callProfiled() int {
+	sum := profiled(1)
+	sum += profiledBoundary(2)
+	// This assertion should fail, because inlinable is inlined.
+	sum += inlinable(3) //gcassert:noinline
+	// This assertion should pass, because notInlinable is too complex to be
+	// inlined.
+	sum += notInlinable(4) //gcassert:noinline
+	// This assertion should fail, because !inline is the same as noinline.
+	sum += inlinable(5) //gcassert:!inline
+	return sum
+}
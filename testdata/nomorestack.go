@@ -0,0 +1,24 @@
+package gcassert
+
+// This assertion should pass, because a leaf function with a small frame
+// doesn't need to check for room on the stack.
+//
+//gcassert:nomorestack
+func addWords(a, b uint64) uint64 {
+	return a + b
+}
+
+//go:noinline
+func fillFrame(buf *[1024]byte) {
+	buf[0] = 1
+}
+
+// This assertion should fail, because the buffer gives the function a large
+// frame, and it calls another function.
+//
+//gcassert:nomorestack
+func largeFrame() byte {
+	var buf [1024]byte
+	fillFrame(&buf)
+	return buf[0]
+}
@@ -0,0 +1,40 @@
+package gcassert
+
+type spillPoint struct {
+	x, y int
+}
+
+//go:noinline
+func makeSpillPoint(a int) spillPoint {
+	return spillPoint{x: a, y: a + 1}
+}
+
+//go:noinline
+func makeSpillQuad(a int) [4]int {
+	return [4]int{a, a + 1, a + 2, a + 3}
+}
+
+//go:noinline
+func spillBarrier() {}
+
+func useSpillPoint(a int) int {
+	// This assertion should pass, because the two fields are returned in
+	// registers and used straight away.
+	p := makeSpillPoint(a) //gcassert:noretspill
+	return p.x + p.y
+}
+
+func useSpillQuad(a int) int {
+	// This assertion should fail, because an array of more than one element
+	// is never returned in registers.
+	q := makeSpillQuad(a) //gcassert:noretspill
+	return q[0] + q[3]
+}
+
+func useSpillPointLater(a int) int {
+	// This assertion should fail, because the fields are still needed after
+	// the next call, which may clobber every register.
+	p := makeSpillPoint(a) //gcassert:noretspill
+	spillBarrier()
+	return p.x + p.y
+}
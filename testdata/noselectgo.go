@@ -0,0 +1,27 @@
+package gcassert
+
+func tryReceive(c chan int) (int, bool) {
+	// This assertion should pass, because a single case with a default is a
+	// non-blocking receive.
+	//
+	//gcassert:noselectgo
+	select {
+	case v := <-c:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+func receiveEither(a, b chan int) int {
+	// This assertion should fail, because a select between two channels
+	// needs the general implementation.
+	//
+	//gcassert:noselectgo
+	select {
+	case v := <-a:
+		return v
+	case v := <-b:
+		return v
+	}
+}
@@ -0,0 +1,22 @@
+package gcassert
+
+//go:noinline
+func threeArgs(a, b, c int) int {
+	return a + b + c
+}
+
+//go:noinline
+func twelveArgs(a, b, c, d, e, f, g, h, i, j, k, l int) int {
+	return a + b + c + d + e + f + g + h + i + j + k + l
+}
+
+func callThreeArgs(x int) int {
+	// This assertion should pass, because all arguments fit in registers.
+	return threeArgs(x, x+1, x+2) //gcassert:nospill
+}
+
+func callTwelveArgs(x int) int {
+	// This assertion should fail, because amd64 only has nine integer
+	// argument registers, so the last three arguments are passed on the stack.
+	return twelveArgs(x, x+1, x+2, x+3, x+4, x+5, x+6, x+7, x+8, x+9, x+10, x+11) //gcassert:nospill
+}
@@ -0,0 +1,26 @@
+package gcassert
+
+type deferCounter struct {
+	closed int
+}
+
+func (c *deferCounter) Close() {
+	c.closed++
+}
+
+func closeOnce(c *deferCounter) int {
+	// This assertion should pass, because a deferred method call is
+	// open-coded like any other.
+	//gcassert:opendefer
+	defer c.Close()
+	return c.closed
+}
+
+func closeAll(cs []*deferCounter) {
+	for _, c := range cs {
+		// This assertion should fail, because a defer in a loop needs a
+		// defer record for each iteration.
+		//gcassert:opendefer
+		defer c.Close()
+	}
+}
@@ -0,0 +1,57 @@
+package gcassert
+
+type incrementer interface {
+	increment() int
+}
+
+type counter struct {
+	n int
+}
+
+//gcassert:inline
+func (c counter) increment() int {
+	return c.n + 1
+}
+
+type embeddingCounter struct {
+	incrementer
+}
+
+func incrementTwice() int {
+	// This assertion should pass, because the method is called directly.
+	sum := counter{n: 1}.increment()
+
+	// This assertion should fail, because the compiler doesn't devirtualize
+	// a method promoted from an embedded interface, even though the embedded
+	// value's concrete type is known.
+	sum += embeddingCounter{counter{n: 2}}.increment()
+	return sum
+}
+
+type stepper struct {
+	n int
+}
+
+// This assertion should pass, because the promoted call that stores a stepper
+// is attributed to this method, rather than to counter's.
+//
+//gcassert:noinline
+func (s stepper) increment() int {
+	return s.n + 2
+}
+
+func incrementLocal() int {
+	// This assertion should fail, like the one in incrementTwice, because e is
+	// only assigned a literal that stores a counter.
+	e := embeddingCounter{counter{n: 3}}
+	return e.increment()
+}
+
+func incrementStepper(n int) int {
+	return embeddingCounter{incrementer: stepper{n: n}}.increment()
+}
+
+// The concrete type stored in e isn't known, so this call isn't checked.
+func incrementAny(e embeddingCounter) int {
+	return e.increment()
+}
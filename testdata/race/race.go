@@ -0,0 +1,18 @@
+package race
+
+func add(a, b int) int {
+	return a + b
+}
+
+func sum(ints []int) int {
+	s := 0
+	for i := range ints {
+		s = add(s, ints[i]) //gcassert:bce,inline
+	}
+	return s
+}
+
+// This assertion should fail, because nothing proves ints is non-empty.
+func first(ints []int) int {
+	return ints[0] //gcassert:bce
+}
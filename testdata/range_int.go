@@ -0,0 +1,26 @@
+package gcassert
+
+func sumRangeInt(ints []int) int {
+	sum := 0
+	// These assertions should pass, because i is bounded by len(ints) like in
+	// the equivalent classic for loop.
+	for i := range len(ints) {
+		sum += ints[i] //gcassert:bce
+	}
+	for i := 0; i < len(ints); i++ {
+		sum += ints[i] //gcassert:bce
+	}
+	return sum
+}
+
+func sumRangeN(ints []int, n int) int {
+	sum := 0
+	for i := range n {
+		// This assertion should fail, because n is unrelated to len(ints).
+		sum += ints[i] //gcassert:bce
+		// This assertion should pass, because the call is inlined in the body
+		// of a range-over-int loop.
+		sum += addInts(i, 1) //gcassert:inline
+	}
+	return sum
+}
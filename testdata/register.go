@@ -0,0 +1,40 @@
+package gcassert
+
+//go:noinline
+func registerBarrier() {}
+
+func sumInRegister(xs []int) int {
+	// This assertion should pass, because nothing clobbers the registers
+	// while sum is live.
+	//
+	//gcassert:register=sum
+	sum := 0
+	for _, x := range xs {
+		sum += x
+	}
+	return sum
+}
+
+// This assertion should fail, because total is live across a call, which may
+// clobber every register.
+//
+//gcassert:register=total
+func sumAcrossCalls(xs []int) int {
+	total := 0
+	for _, x := range xs {
+		total += x
+		registerBarrier()
+	}
+	return total
+}
+
+func sumMisspelled(xs []int) int {
+	// This assertion should fail, because there's no variable named sun.
+	//
+	//gcassert:register=sun
+	sum := 0
+	for _, x := range xs {
+		sum += x
+	}
+	return sum
+}
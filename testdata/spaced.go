@@ -0,0 +1,21 @@
+package gcassert
+
+func addSpaced(a, b int) int {
+	return a + b
+}
+
+func spacedDirectives(ints []int) int {
+	if len(ints) < 2 {
+		return 0
+	}
+	//gcassert: bce
+	a := ints[0]
+	b := addSpaced(a, ints[1]) // gcassert: inline , bce
+	// This assertion should fail, because there's no call on the line to
+	// inline.
+	c := ints[1] //gcassert:bce, inline
+	// This assertion should fail, because spaces don't make an unknown
+	// directive valid.
+	d := ints[0] //gcassert:bce, fast
+	return b + c + d
+}
@@ -0,0 +1,22 @@
+package gcassert
+
+func sumProved(xs []int) int {
+	s := 0
+	for i := 0; i < len(xs); i++ {
+		s += xs[i] //gcassert:ssa=prove
+	}
+	return s
+}
+
+func boolToInt(b bool) int {
+	x := 0
+	if b {
+		x = 1
+	}
+	return x //gcassert:ssa=phiopt
+}
+
+// This assertion should fail, because nothing is known about i.
+func indexUnproved(xs []int, i int) int {
+	return xs[i] //gcassert:ssa=prove
+}
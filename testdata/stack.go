@@ -0,0 +1,21 @@
+package gcassert
+
+type stackPair struct{ a, b int }
+
+var stackSink *stackPair
+
+func stackAllocs(n int) int {
+	// This assertion should pass, because the compiler proves that p doesn't
+	// escape.
+	p := &stackPair{a: n} //gcassert:stack
+	// This assertion should fail, because q is stored in a global.
+	q := &stackPair{a: n} //gcassert:stack
+	stackSink = q
+	// This assertion should fail, because r's address is stored in a global.
+	r := stackPair{b: n} //gcassert:stack
+	stackSink = &r
+	// This assertion should fail, because a value that's never referenced
+	// isn't an allocation that the compiler reports on.
+	x := 3 //gcassert:stack
+	return p.a + x
+}
@@ -0,0 +1,24 @@
+package gcassert
+
+import "strings"
+
+// This assertion should pass, because the table is laid out by the linker.
+//
+//gcassert:staticinit
+var staticTable = [...]int{1, 2, 3, 4}
+
+// This assertion should fail, because strings.ToUpper must be called by the
+// package's init function.
+//
+//gcassert:staticinit
+var dynamicString = strings.ToUpper("gcassert")
+
+var (
+	// This assertion should fail, because the call on the second line of
+	// the initializer must be made by the package's init function.
+	//gcassert:staticinit
+	dynamicTable = []string{
+		"gcassert",
+		strings.ToLower("GCASSERT"),
+	}
+)
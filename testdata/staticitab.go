@@ -0,0 +1,22 @@
+package gcassert
+
+import "io"
+
+type nopFile struct{}
+
+func (nopFile) Read(p []byte) (int, error) { return 0, io.EOF }
+func (nopFile) Close() error               { return nil }
+
+var readerSink io.Reader
+
+func setFileReader(f *nopFile) {
+	// This assertion should pass, because the itab for *nopFile and
+	// io.Reader is built at compile time.
+	readerSink = f //gcassert:staticitab
+}
+
+func setReadCloserReader(rc io.ReadCloser) {
+	// This assertion should fail, because the dynamic type of rc is only
+	// known at run time, so its io.Reader itab must be looked up.
+	readerSink = rc //gcassert:staticitab
+}
@@ -0,0 +1,47 @@
+package strict
+
+func sum(ints []int) int {
+	total := 0
+	for i := range ints {
+		total += ints[i] //gcassert:bce
+		total += ints[i] //gcassert:bce // A trailing comment is allowed.
+		// gcassert fails these assertions, because the comments are malformed.
+		total += ints[i] //gcassert:bce,
+		total += ints[i] //gc-assert:bce
+		total += ints[i] // gcassert:bce because i is in range
+		total += ints[i] //GCAssert:bce
+	}
+	return total
+}
+
+type point struct{ x, y int }
+
+func scale(ints []int, p point) int {
+	// These assertions should fail, because their lines have no index
+	// expression or value that could escape.
+	//gcassert:bce
+	n := len(ints)
+	//gcassert:noescape
+	n *= 2
+	q := point{x: p.x * n} //gcassert:noescape
+	return q.x
+}
+
+// This assertion should pass, because double is called and inlined.
+//
+//gcassert:inline
+func double(x int) int {
+	return 2 * x
+}
+
+func useDouble(x int) int {
+	return double(x)
+}
+
+// This assertion should fail, because nothing calls unused, so whether it's
+// inlined is never checked.
+//
+//gcassert:inline
+func unused(x int) int {
+	return x + 1
+}
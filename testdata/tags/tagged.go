@@ -0,0 +1,9 @@
+//go:build gcassert_tagged
+
+package tags
+
+// This assertion should fail, but it's only checked with the
+// gcassert_tagged build tag.
+func first(ints []int) int {
+	return ints[0] //gcassert:bce
+}
@@ -0,0 +1,10 @@
+package tags
+
+// This assertion should pass, because this file is always built.
+func sum(ints []int) int {
+	s := 0
+	for i := range ints {
+		s += ints[i] //gcassert:bce
+	}
+	return s
+}
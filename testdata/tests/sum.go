@@ -0,0 +1,10 @@
+package tests
+
+//gcassert:inline
+func sum(ints []int) int {
+	total := 0
+	for i := range ints {
+		total += ints[i] //gcassert:bce
+	}
+	return total
+}
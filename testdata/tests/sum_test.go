@@ -0,0 +1,14 @@
+package tests
+
+import "testing"
+
+var sink int
+
+func BenchmarkSum(b *testing.B) {
+	ints := make([]int, 8)
+	for i := 0; i < b.N; i++ {
+		sink = sum(ints)
+		// This assertion fails, because nothing proves i is in bounds.
+		sink += ints[i] //gcassert:bce
+	}
+}
@@ -0,0 +1,7 @@
+package toolchain
+
+// This assertion should fail on every toolchain, because nothing proves ints
+// is non-empty.
+func first(ints []int) int {
+	return ints[0] //gcassert:bce
+}
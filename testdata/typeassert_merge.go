@@ -0,0 +1,30 @@
+package gcassert
+
+type assertedPair struct {
+	a, b int
+}
+
+type assertedIface interface {
+	assertedMethod()
+}
+
+func (assertedPair) assertedMethod() {}
+
+// This assertion should pass, because the compiler reuses the first assertion
+// to a concrete type.
+//
+//gcassert:typeassertmerge
+func sumAsserted(i any) int {
+	a := i.(*assertedPair).a
+	b := i.(*assertedPair).b
+	return a + b
+}
+
+// This assertion should fail, because each assertion to an interface type
+// looks up the method table again.
+//
+//gcassert:typeassertmerge
+func callAssertedTwice(i any) {
+	i.(assertedIface).assertedMethod()
+	i.(assertedIface).assertedMethod()
+}
@@ -0,0 +1,6 @@
+package typeerror
+
+// This package doesn't type check, so its directives can't be checked.
+func first(ints []int) string {
+	return ints[0] //gcassert:bce
+}
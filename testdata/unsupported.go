@@ -0,0 +1,48 @@
+package gcassert
+
+// This assertion should fail, because function placement can't be checked.
+//
+//gcassert:hot
+func hotFunction() {}
+
+func scaleAll(ints []int, a, b int) {
+	for i := range ints {
+		// This assertion should fail, because hoisting can't be checked.
+		ints[i] *= a * b //gcassert:hoist
+	}
+}
+
+func localChannel() int {
+	// This assertion should fail, because channels are never stack allocated.
+	c := make(chan int, 1) //gcassert:stackchan
+	c <- 1
+	return <-c
+}
+
+func dotProduct(a, b []float64) float64 {
+	sum := 0.0
+	// This assertion should fail, because loops are never vectorized.
+	//
+	//gcassert:simd
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+// This assertion should fail, because variables are never read-only.
+//
+//gcassert:rodata
+var hexDigits = [16]byte{'0', '1', '2', '3', '4', '5', '6', '7', '8', '9', 'a', 'b', 'c', 'd', 'e', 'f'}
+
+func fillPresized() []int {
+	s := make([]int, 0, 8)
+	// This assertion should fail, because appends in a loop always may grow
+	// the slice.
+	//
+	//gcassert:noresize
+	for i := 0; i < 8; i++ {
+		s = append(s, i)
+	}
+	return s
+}
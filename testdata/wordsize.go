@@ -0,0 +1,26 @@
+package gcassert
+
+import "unsafe"
+
+// This assertion should pass, because a pointer is a single word.
+//
+//gcassert:wordsize
+type taggedPointer struct {
+	p unsafe.Pointer
+}
+
+// This assertion should pass, because the fields pack into a single word.
+//
+//gcassert:wordsize
+type packedState struct {
+	version uint32
+	flags   uint16
+	kind    uint8
+}
+
+// This assertion should fail, because a slice header is three words.
+//
+//gcassert:wordsize
+type sliceHolder struct {
+	s []int
+}
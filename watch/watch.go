@@ -0,0 +1,93 @@
+// Package watch runs gcassert on a set of packages again each time one of
+// their Go files changes.
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/fmstephe/gcassert"
+	"github.com/fsnotify/fsnotify"
+)
+
+// debounce is how long Watch waits after a file changes before it runs
+// again, since editors often write a file in several steps.
+const debounce = 200 * time.Millisecond
+
+// Watch runs gcassert.GCAssert on the packages at paths, and then again each
+// time a Go file in one of their directories changes, writing the failures of
+// each run to w. Each run checks every directive from scratch, so a failure
+// that has been fixed isn't reported again. An error that stops a run, such as
+// a package that doesn't compile, is written to w as well, and Watch carries
+// on watching. It only returns if the files can't be watched.
+func Watch(w io.Writer, paths ...string) error {
+	return WithOptions(w, gcassert.Options{}, paths...)
+}
+
+// WithOptions performs the same operation as Watch, but allows the build to
+// be configured with opts.
+func WithOptions(w io.Writer, opts gcassert.Options, paths ...string) error {
+	return watch(w, opts, debounce, nil, nil, paths...)
+}
+
+// watch implements WithOptions. It calls ran, if it isn't nil, after each
+// run, and returns when stop is closed.
+func watch(w io.Writer, opts gcassert.Options, debounce time.Duration, ran func(), stop <-chan struct{}, paths ...string) error {
+	dirs, err := gcassert.PackageDirs(opts, paths...)
+	if err != nil {
+		return err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			return err
+		}
+	}
+
+	run := func() error {
+		err := gcassert.GCAssertWithOptions(w, opts, paths...)
+		if err != nil && !errors.Is(err, gcassert.ErrAssertionsFailed) {
+			if _, err := fmt.Fprintln(w, err); err != nil {
+				return err
+			}
+		}
+		if ran != nil {
+			ran()
+		}
+		return nil
+	}
+	if err := run(); err != nil {
+		return err
+	}
+	var rerun <-chan time.Time
+	for {
+		select {
+		case <-stop:
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Ext(event.Name) == ".go" && !event.Has(fsnotify.Chmod) {
+				rerun = time.After(debounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+		case <-rerun:
+			rerun = nil
+			if err := run(); err != nil {
+				return err
+			}
+		}
+	}
+}
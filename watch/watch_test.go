@@ -0,0 +1,77 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fmstephe/gcassert"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, src string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module example.com/watch\n\ngo 1.22\n")
+	write("watch.go", `package watch
+
+func first(ints []int) int {
+	if len(ints) == 0 {
+		return 0
+	}
+	return ints[0] //gcassert:bce
+}
+`)
+
+	var w strings.Builder
+	ran := make(chan struct{})
+	stop := make(chan struct{})
+	watched := make(chan error)
+	go func() {
+		watched <- watch(&w, gcassert.Options{Cwd: dir, NoLog: true}, 100*time.Millisecond, func() { ran <- struct{}{} }, stop, ".")
+	}()
+	wait := func() {
+		select {
+		case <-ran:
+		case err := <-watched:
+			t.Fatal(err)
+		case <-time.After(time.Minute):
+			t.Fatal("timed out waiting for a run")
+		}
+	}
+	wait()
+	assert.Equal(t, "gcassert: 1 directive checked, 0 failed\n", w.String())
+
+	w.Reset()
+	write("watch.go", `package watch
+
+func first(ints []int) int {
+	return ints[0] //gcassert:bce
+}
+`)
+	wait()
+	assert.Equal(t, "watch.go:4:13:\treturn ints[0]: Found IsInBounds\ngcassert: 1 directive checked, 1 failed (1 bce)\n", w.String())
+
+	// The failure isn't reported again once it's fixed.
+	w.Reset()
+	write("watch.go", `package watch
+
+func first(ints []int) int {
+	if len(ints) == 0 {
+		return 0
+	}
+	return ints[0] //gcassert:bce
+}
+`)
+	wait()
+	assert.Equal(t, "gcassert: 1 directive checked, 0 failed\n", w.String())
+
+	close(stop)
+	assert.NoError(t, <-watched)
+}